@@ -12,7 +12,7 @@ import (
 var version string
 
 func main() {
-	fmt.Println("Jai Guru!")
+	fmt.Fprintln(os.Stderr, "Jai Guru!")
 	app := cli.NewApp()
 	app.Name = "drone"
 	app.Version = version
@@ -21,6 +21,14 @@ func main() {
 
 	app.Commands = []*cli.Command{
 		drone.Command,
+		drone.DoctorCommand,
+		drone.LintCommand,
+		drone.ListCommand,
+		drone.LogsCommand,
+		drone.MergeCommand,
+		drone.PruneCommand,
+		drone.RunsCommand,
+		drone.VerifyImageCommand,
 	}
 
 	if err := app.Run(os.Args); err != nil {
@@ -0,0 +1,54 @@
+package drone
+
+import (
+	"runtime/debug"
+
+	"github.com/in-toto/in-toto-golang/in_toto/slsa_provenance/common"
+)
+
+// builderID identifies the builder in the provenance
+const builderID = "https://harness.drone.io/Attestations/DockerRunner"
+
+// builderModules are the modules whose versions are recorded in the builder version
+var builderModules = map[string]string{
+	"github.com/drone-runners/drone-runner-docker": "drone-runner-docker",
+	"github.com/drone/runner-go":                   "runner-go",
+	"github.com/drone/drone-go":                    "drone-go",
+	"github.com/in-toto/in-toto-golang":            "in-toto-golang",
+	"github.com/google/go-containerregistry":       "go-containerregistry",
+}
+
+// provenanceBuilder is the provenance builder with the versions of
+// the tool and the libraries used to run the pipeline
+type provenanceBuilder struct {
+	common.ProvenanceBuilder
+	Version map[string]string `json:"version,omitempty"`
+}
+
+// newProvenanceBuilder returns the builder with the tool version and
+// the versions of the runner libraries read from the build info
+func newProvenanceBuilder(toolVersion string) provenanceBuilder {
+	b := provenanceBuilder{
+		ProvenanceBuilder: common.ProvenanceBuilder{
+			ID: builderID,
+		},
+		Version: map[string]string{},
+	}
+	if toolVersion != "" {
+		b.Version["drone-provenance"] = toolVersion
+	}
+	bi, ok := debug.ReadBuildInfo()
+	if !ok {
+		return b
+	}
+	b.Version["go"] = bi.GoVersion
+	for _, dep := range bi.Deps {
+		if dep.Replace != nil {
+			dep = dep.Replace
+		}
+		if n, ok := builderModules[dep.Path]; ok {
+			b.Version[n] = dep.Version
+		}
+	}
+	return b
+}
@@ -0,0 +1,19 @@
+package drone
+
+import (
+	"context"
+	"fmt"
+)
+
+// originRemoteURL returns the URL of the origin remote of the git work tree in
+// the current directory, the repository cloned by default with --clone
+func originRemoteURL(ctx context.Context) (string, error) {
+	lines, err := git(ctx, "remote", "get-url", "origin")
+	if err != nil {
+		return "", err
+	}
+	if len(lines) == 0 {
+		return "", fmt.Errorf("the origin remote has no URL")
+	}
+	return lines[0], nil
+}
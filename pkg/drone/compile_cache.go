@@ -0,0 +1,204 @@
+package drone
+
+import (
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/json"
+	"fmt"
+	"os"
+	"path"
+	"sort"
+	"time"
+
+	"github.com/drone-runners/drone-runner-docker/engine"
+	"github.com/drone-runners/drone-runner-docker/engine/compiler"
+	"github.com/drone/drone-go/drone"
+)
+
+// compileCacheInputs are the inputs of the compilation of the pipeline, any
+// change of the inputs invalidates the cached compiled pipeline
+type compileCacheInputs struct {
+	ToolVersion       string
+	Source            string
+	SourceDigest      string
+	Includes          []includedFile
+	Envs              map[string]string
+	Stage             string
+	PipelineIndex     int
+	Environ           map[string]string
+	Labels            map[string]string
+	Volumes           map[string]string
+	Privileged        []string
+	Networks          []string
+	Resources         compiler.Resources
+	Tmate             compiler.Tmate
+	Clone             bool
+	Mount             string
+	PipelineFileLabel string
+	Build             *drone.Build
+	Repo              *drone.Repo
+	Netrc             *drone.Netrc
+	System            *drone.System
+}
+
+// maxCompileCacheEntries is the number of the cached compiled pipelines kept,
+// the least recently used are evicted beyond it
+const maxCompileCacheEntries = 50
+
+// compileCacheDir returns the directory of the cached compiled pipelines
+func compileCacheDir() string {
+	return path.Join(droneCIHome, "cache")
+}
+
+// isCompileCacheable tells whether the compiled pipeline can be cached. The
+// pipelines with secrets, registry or netrc credentials are not cached so that
+// the credentials are never written to the disk.
+func isCompileCacheable(commy *execCommand) bool {
+	return !commy.NoCompileCache &&
+		len(commy.Secrets) == 0 &&
+		commy.Config == "" &&
+		commy.Netrc.Password == ""
+}
+
+// compileCacheKey returns the hash of the inputs of the compiled pipeline
+func compileCacheKey(commy *execCommand, mount string) (string, error) {
+	b, err := json.Marshal(compileCacheInputs{
+		ToolVersion:       commy.ToolVersion,
+		Source:            commy.Source,
+		SourceDigest:      commy.SourceDigest,
+		Includes:          commy.Includes,
+		Envs:              commy.Envs,
+		Stage:             commy.Stage.Name,
+		PipelineIndex:     commy.PipelineIndex,
+		Environ:           commy.Environ,
+		Labels:            commy.Labels,
+		Volumes:           commy.Volumes,
+		Privileged:        commy.Privileged,
+		Networks:          commy.Networks,
+		Resources:         commy.Resources,
+		Tmate:             commy.Tmate,
+		Clone:             commy.Clone,
+		Mount:             mount,
+		PipelineFileLabel: commy.PipelineFileLabel,
+		Build:             commy.Build,
+		Repo:              commy.Repo,
+		Netrc:             commy.Netrc,
+		System:            commy.System,
+	})
+	if err != nil {
+		return "", err
+	}
+	return fmt.Sprintf("%x", sha256.Sum256(b)), nil
+}
+
+// readCachedSpec reads the compiled pipeline cached with the key, nil when
+// the pipeline was not cached. The identifiers of the network, containers and
+// volumes of the cached pipeline are regenerated so that the runs do not
+// collide.
+func readCachedSpec(key string) *engine.Spec {
+	f := path.Join(compileCacheDir(), key+".json")
+	b, err := os.ReadFile(f)
+	if err != nil {
+		return nil
+	}
+	var spec engine.Spec
+	if err := json.Unmarshal(b, &spec); err != nil {
+		log.Debugf("Ignoring the invalid cached compiled pipeline %s,%v", key, err)
+		return nil
+	}
+	// the recently used pipelines are the last evicted
+	now := time.Now()
+	_ = os.Chtimes(f, now, now)
+	regenerateSpecIDs(&spec)
+	return &spec
+}
+
+// regenerateSpecIDs replaces the identifiers generated by the compiler with
+// new ones, the volumes named after their identifier and their mounts are
+// renamed accordingly.
+func regenerateSpecIDs(spec *engine.Spec) {
+	ids := map[string]string{}
+	newID := func(id string) string {
+		if id == "" {
+			return id
+		}
+		ids[id] = newSpecID()
+		return ids[id]
+	}
+	spec.Network.ID = newID(spec.Network.ID)
+	for _, v := range spec.Volumes {
+		switch {
+		case v.EmptyDir != nil:
+			v.EmptyDir.ID = newID(v.EmptyDir.ID)
+		case v.HostPath != nil:
+			id := v.HostPath.ID
+			v.HostPath.ID = newID(id)
+			if v.HostPath.Name == id {
+				v.HostPath.Name = v.HostPath.ID
+			}
+		}
+	}
+	for _, s := range append(spec.Steps, spec.Internal...) {
+		s.ID = newID(s.ID)
+		for _, m := range s.Volumes {
+			if id, ok := ids[m.Name]; ok {
+				m.Name = id
+			}
+		}
+	}
+}
+
+// newSpecID returns a random identifier in the format of the compiler
+func newSpecID() string {
+	b := make([]byte, 10)
+	_, _ = rand.Read(b)
+	return fmt.Sprintf("drone-%x", b)
+}
+
+// writeCachedSpec caches the compiled pipeline with the key
+func writeCachedSpec(key string, spec *engine.Spec) error {
+	if err := os.MkdirAll(compileCacheDir(), 0o755); err != nil {
+		return err
+	}
+	b, err := json.Marshal(spec)
+	if err != nil {
+		return err
+	}
+	if err := os.WriteFile(path.Join(compileCacheDir(), key+".json"), b, 0o600); err != nil {
+		return err
+	}
+	evictCachedSpecs(maxCompileCacheEntries)
+	return nil
+}
+
+// evictCachedSpecs removes the least recently used compiled pipelines beyond
+// the max entries
+func evictCachedSpecs(max int) {
+	entries, err := os.ReadDir(compileCacheDir())
+	if err != nil {
+		return
+	}
+	type cached struct {
+		file    string
+		modTime time.Time
+	}
+	var files []cached
+	for _, e := range entries {
+		info, err := e.Info()
+		if err != nil || e.IsDir() {
+			continue
+		}
+		files = append(files, cached{path.Join(compileCacheDir(), e.Name()), info.ModTime()})
+	}
+	if len(files) <= max {
+		return
+	}
+	sort.Slice(files, func(i, j int) bool {
+		return files[i].modTime.After(files[j].modTime)
+	})
+	for _, f := range files[max:] {
+		if err := os.Remove(f.file); err != nil {
+			log.Debugf("Unable to evict the cached compiled pipeline %s,%v", f.file, err)
+		}
+	}
+}
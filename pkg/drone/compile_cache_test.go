@@ -0,0 +1,77 @@
+package drone
+
+import (
+	"fmt"
+	"os"
+	"path"
+	"testing"
+	"time"
+
+	"github.com/drone-runners/drone-runner-docker/engine"
+)
+
+func TestReadCachedSpecRegeneratesIDs(t *testing.T) {
+	droneCIHome = t.TempDir()
+	spec := &engine.Spec{
+		Network: engine.Network{ID: "drone-network"},
+		Volumes: []*engine.Volume{
+			{EmptyDir: &engine.VolumeEmptyDir{ID: "drone-workspace", Name: "_workspace"}},
+			{HostPath: &engine.VolumeHostPath{ID: "drone-host", Name: "drone-host", Path: "/data"}},
+		},
+		Steps: []*engine.Step{{
+			ID:   "drone-step",
+			Name: "build",
+			Volumes: []*engine.VolumeMount{
+				{Name: "_workspace", Path: "/drone/src"},
+				{Name: "drone-host", Path: "/data"},
+			},
+		}},
+	}
+	if err := writeCachedSpec("key", spec); err != nil {
+		t.Fatal(err)
+	}
+	first, second := readCachedSpec("key"), readCachedSpec("key")
+	if first == nil || second == nil {
+		t.Fatal("expecting the cached compiled pipeline")
+	}
+	for _, c := range []*engine.Spec{first, second} {
+		if c.Network.ID == spec.Network.ID || c.Steps[0].ID == spec.Steps[0].ID ||
+			c.Volumes[0].EmptyDir.ID == spec.Volumes[0].EmptyDir.ID || c.Volumes[1].HostPath.ID == spec.Volumes[1].HostPath.ID {
+			t.Errorf("expecting the identifiers to be regenerated, %+v", c)
+		}
+		if got, want := c.Steps[0].Volumes[1].Name, c.Volumes[1].HostPath.Name; got != want {
+			t.Errorf("host volume mounted as %s, want %s", got, want)
+		}
+		if got := c.Steps[0].Volumes[0].Name; got != "_workspace" {
+			t.Errorf("workspace mounted as %s, want _workspace", got)
+		}
+	}
+	if first.Network.ID == second.Network.ID || first.Steps[0].ID == second.Steps[0].ID {
+		t.Error("expecting the runs of the cached pipeline not to share identifiers")
+	}
+}
+
+func TestEvictCachedSpecs(t *testing.T) {
+	droneCIHome = t.TempDir()
+	if err := os.MkdirAll(compileCacheDir(), 0o755); err != nil {
+		t.Fatal(err)
+	}
+	now := time.Now()
+	for i := 0; i < 5; i++ {
+		f := path.Join(compileCacheDir(), fmt.Sprintf("%d.json", i))
+		if err := os.WriteFile(f, []byte("{}"), 0o600); err != nil {
+			t.Fatal(err)
+		}
+		mtime := now.Add(time.Duration(i) * time.Minute)
+		if err := os.Chtimes(f, mtime, mtime); err != nil {
+			t.Fatal(err)
+		}
+	}
+	evictCachedSpecs(3)
+	for i := 0; i < 5; i++ {
+		_, err := os.Stat(path.Join(compileCacheDir(), fmt.Sprintf("%d.json", i)))
+		if evicted := os.IsNotExist(err); evicted != (i < 2) {
+			t.Errorf("cached pipeline %d evicted %v", i, evicted)
+		}
+	}
+}
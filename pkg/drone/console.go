@@ -0,0 +1,82 @@
+package drone
+
+import (
+	"context"
+	"fmt"
+	"io"
+
+	"github.com/drone/runner-go/pipeline"
+)
+
+// console line formats with the step name and line number, colored on a terminal
+const (
+	consolePlainf  = "[%s:%d] %s\n"
+	consolePrettyf = "\033[%s[%s:%d]\033[0m %s\n"
+)
+
+// consoleColors are the terminal colors of the steps
+var consoleColors = []string{
+	"32m", // green
+	"33m", // yellow
+	"34m", // blue
+	"35m", // magenta
+	"36m", // cyan
+}
+
+// consoleStreamer writes the step logs to the console writer, as the console
+// streamer of the runner does with os.Stdout.
+type consoleStreamer struct {
+	out    io.Writer
+	pretty bool
+	seq    *sequence
+	col    *sequence
+}
+
+var _ pipeline.Streamer = (*consoleStreamer)(nil)
+
+func newConsoleStreamer(out io.Writer, pretty bool) *consoleStreamer {
+	return &consoleStreamer{
+		out:    out,
+		pretty: pretty,
+		seq:    new(sequence),
+		col:    new(sequence),
+	}
+}
+
+// Stream implements pipeline.Streamer
+func (c *consoleStreamer) Stream(_ context.Context, _ *pipeline.State, name string) io.WriteCloser {
+	w := &consoleWriter{
+		out:  c.out,
+		name: name,
+		seq:  c.seq,
+	}
+	if c.pretty {
+		w.color = consoleColors[c.col.next()%len(consoleColors)]
+	}
+	return w
+}
+
+// consoleWriter writes the log lines of a step
+type consoleWriter struct {
+	out   io.Writer
+	name  string
+	color string
+	seq   *sequence
+}
+
+// Write implements io.WriteCloser
+func (w *consoleWriter) Write(b []byte) (int, error) {
+	for _, part := range split(b) {
+		if w.color != "" {
+			fmt.Fprintf(w.out, consolePrettyf, w.color, w.name, w.seq.next(), part)
+		} else {
+			fmt.Fprintf(w.out, consolePlainf, w.name, w.seq.next(), part)
+		}
+	}
+	return len(b), nil
+}
+
+// Close implements io.WriteCloser
+func (w *consoleWriter) Close() error {
+	return nil
+}
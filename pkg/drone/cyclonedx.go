@@ -0,0 +1,193 @@
+package drone
+
+import (
+	"crypto/rand"
+	"fmt"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/drone-runners/drone-runner-docker/engine/resource"
+	"github.com/google/go-containerregistry/pkg/name"
+	intoto "github.com/in-toto/in-toto-golang/in_toto"
+	"github.com/in-toto/in-toto-golang/in_toto/slsa_provenance/common"
+)
+
+// supported provenance formats
+const (
+	formatInToto    = "in-toto"
+	formatCycloneDX = "cyclonedx"
+)
+
+// cycloneDXSpecVersion is the CycloneDX specification version of the generated BOM
+const cycloneDXSpecVersion = "1.4"
+
+// cycloneDXHashAlgs maps the digest algorithms to the CycloneDX hash algorithms
+var cycloneDXHashAlgs = map[string]string{
+	"sha256": "SHA-256",
+	"sha512": "SHA-512",
+}
+
+// cdxBOM is a CycloneDX bill of materials
+type cdxBOM struct {
+	BOMFormat    string         `json:"bomFormat"`
+	SpecVersion  string         `json:"specVersion"`
+	SerialNumber string         `json:"serialNumber"`
+	Version      int            `json:"version"`
+	Metadata     cdxMetadata    `json:"metadata"`
+	Components   []cdxComponent `json:"components,omitempty"`
+}
+
+// cdxMetadata is the CycloneDX BOM metadata describing the build
+type cdxMetadata struct {
+	Timestamp  string        `json:"timestamp"`
+	Tools      []cdxTool     `json:"tools,omitempty"`
+	Component  cdxComponent  `json:"component"`
+	Properties []cdxProperty `json:"properties,omitempty"`
+}
+
+// cdxTool is a tool used to build and describe the BOM
+type cdxTool struct {
+	Name    string `json:"name"`
+	Version string `json:"version,omitempty"`
+}
+
+// cdxComponent is a CycloneDX component
+type cdxComponent struct {
+	Type       string         `json:"type"`
+	Name       string         `json:"name"`
+	PURL       string         `json:"purl,omitempty"`
+	Hashes     []cdxHash      `json:"hashes,omitempty"`
+	Properties []cdxProperty  `json:"properties,omitempty"`
+	Components []cdxComponent `json:"components,omitempty"`
+}
+
+// cdxHash is a CycloneDX component hash
+type cdxHash struct {
+	Alg     string `json:"alg"`
+	Content string `json:"content"`
+}
+
+// cdxProperty is a CycloneDX name value property
+type cdxProperty struct {
+	Name  string `json:"name"`
+	Value string `json:"value"`
+}
+
+// validateProvenanceFormat checks if f is one of the supported provenance formats
+func validateProvenanceFormat(f string) error {
+	switch f {
+	case formatInToto, formatCycloneDX:
+		return nil
+	}
+	return fmt.Errorf("unsupported provenance format '%s', expecting one of [%s %s]", f, formatInToto, formatCycloneDX)
+}
+
+// cycloneDXBOM maps the build materials, subjects and metadata into a CycloneDX BOM,
+// the materials are the components and the subjects are the components of the
+// pipeline described by the BOM metadata.
+func cycloneDXBOM(commy *execCommand, p *resource.Pipeline, mat []material, subjects []intoto.Subject) cdxBOM {
+	builder := newProvenanceBuilder(commy.ToolVersion)
+	tools := make([]cdxTool, 0, len(builder.Version))
+	for n, v := range builder.Version {
+		tools = append(tools, cdxTool{Name: n, Version: v})
+	}
+	sort.Slice(tools, func(i, j int) bool { return tools[i].Name < tools[j].Name })
+
+	subject := cdxComponent{
+		Type: "application",
+		Name: p.Name,
+	}
+	for _, s := range subjects {
+		subject.Components = append(subject.Components, cdxComponent{
+			Type:   "file",
+			Name:   s.Name,
+			Hashes: cdxHashes(s.Digest),
+		})
+	}
+
+	components := make([]cdxComponent, 0, len(mat))
+	for _, m := range mat {
+		components = append(components, cdxMaterialComponent(m))
+	}
+
+	return cdxBOM{
+		BOMFormat:    "CycloneDX",
+		SpecVersion:  cycloneDXSpecVersion,
+		SerialNumber: "urn:uuid:" + newUUID(),
+		Version:      1,
+		Metadata: cdxMetadata{
+			Timestamp: time.Now().UTC().Format(time.RFC3339),
+			Tools:     tools,
+			Component: subject,
+			Properties: []cdxProperty{
+				{Name: "drone:builder.id", Value: builder.ID},
+				{Name: "drone:build.type", Value: p.Kind + "/" + p.Type},
+				{Name: "drone:build.invocationId", Value: fmt.Sprintf("%d", commy.Build.ID)},
+				{Name: "drone:build.trusted", Value: strconv.FormatBool(commy.Repo.Trusted)},
+			},
+		},
+		Components: components,
+	}
+}
+
+// cdxMaterialComponent maps the material to a component, the images are
+// container components and the pipeline source is a file component
+func cdxMaterialComponent(m material) cdxComponent {
+	c := cdxComponent{
+		Type:   "file",
+		Name:   strings.TrimPrefix(m.URI, "file:"),
+		Hashes: cdxHashes(m.Digest),
+	}
+	if strings.HasPrefix(m.URI, "pkg:") {
+		image := strings.TrimPrefix(materialKey(m.URI), "pkg:")
+		c.Type = "container"
+		c.Name = image
+		c.PURL = imagePURL(image, m.Digest["sha256"])
+	}
+	keys := make([]string, 0, len(m.Annotations))
+	for k := range m.Annotations {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+	for _, k := range keys {
+		c.Properties = append(c.Properties, cdxProperty{Name: "drone:" + k, Value: m.Annotations[k]})
+	}
+	return c
+}
+
+// cdxHashes maps the digest set to CycloneDX hashes
+func cdxHashes(ds common.DigestSet) []cdxHash {
+	var hashes []cdxHash
+	for alg, d := range ds {
+		if a, ok := cycloneDXHashAlgs[alg]; ok && d != "" {
+			hashes = append(hashes, cdxHash{Alg: a, Content: d})
+		}
+	}
+	sort.Slice(hashes, func(i, j int) bool { return hashes[i].Alg < hashes[j].Alg })
+	return hashes
+}
+
+// imagePURL returns the package URL of the image with its digest
+func imagePURL(image, digest string) string {
+	ref, err := name.ParseReference(image)
+	if err != nil || digest == "" {
+		return ""
+	}
+	repo := ref.Context()
+	purl := fmt.Sprintf("pkg:docker/%s@sha256:%s", repo.RepositoryStr(), digest)
+	if repo.RegistryStr() != name.DefaultRegistry {
+		purl += "?repository_url=" + repo.Name()
+	}
+	return purl
+}
+
+// newUUID returns a random version 4 UUID
+func newUUID() string {
+	b := make([]byte, 16)
+	_, _ = rand.Read(b)
+	b[6] = (b[6] & 0x0f) | 0x40
+	b[8] = (b[8] & 0x3f) | 0x80
+	return fmt.Sprintf("%x-%x-%x-%x-%x", b[0:4], b[4:6], b[6:8], b[8:10], b[10:])
+}
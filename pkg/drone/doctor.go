@@ -0,0 +1,128 @@
+package drone
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"os"
+	"path"
+	"runtime"
+	"strings"
+	"time"
+
+	"github.com/kameshsampath/drone-provenance/pkg/utils"
+	"github.com/urfave/cli/v2"
+)
+
+// check is a single diagnostic performed by the doctor command
+type check struct {
+	name string
+	run  func(ctx context.Context) (string, error)
+}
+
+// DoctorCommand exports the doctor command.
+var DoctorCommand = &cli.Command{
+	Name:  "doctor",
+	Usage: "diagnose the environment used to run local builds",
+	Flags: []cli.Flag{
+		&cli.DurationFlag{
+			Name:  "timeout",
+			Usage: "timeout for each of the checks",
+			Value: 2 * time.Minute,
+		},
+		&cli.StringFlag{
+			Name:  "registry",
+			Usage: "registry file with the credentials to pull the UI refresh image",
+		},
+		&cli.StringFlag{
+			Name:  "context",
+			Usage: "name of the docker context to diagnose, instead of the docker environment variables",
+		},
+	},
+	Action: doctor,
+}
+
+func doctor(cliContext *cli.Context) error {
+	// the docker context must be in use before the docker client is created,
+	// the same as exec
+	if err := utils.UseDockerContext(cliContext.String("context")); err != nil {
+		return withKind(ErrDockerUnavailable, err)
+	}
+	checks := []check{
+		{"Docker connectivity", checkDocker},
+		{"Logs directory writable", checkLogsDir},
+	}
+	if runtime.GOOS == "darwin" {
+		checks = append(checks, check{"Extension socket", checkExtensionSocket})
+	}
+	refreshImage := utils.UIRefreshImage()
+	checks = append(checks, check{"Pull " + refreshImage, func(ctx context.Context) (string, error) {
+		return checkPull(ctx, refreshImage, cliContext.String("registry"), cliContext.App.Writer)
+	}})
+
+	out := cliContext.App.Writer
+	failed := 0
+	for _, c := range checks {
+		ctx, cancel := context.WithTimeout(nocontext, cliContext.Duration("timeout"))
+		msg, err := c.run(ctx)
+		cancel()
+		if err != nil {
+			failed++
+			fmt.Fprintf(out, "[FAIL] %s: %v\n", c.name, err)
+			continue
+		}
+		fmt.Fprintln(out, strings.TrimSpace("[PASS] "+c.name+" "+msg))
+	}
+	if failed > 0 {
+		return fmt.Errorf("%d of %d checks failed", failed, len(checks))
+	}
+	return nil
+}
+
+func checkDocker(ctx context.Context) (string, error) {
+	var err error
+	dockerCli, err = utils.DockerCliClient()
+	if err != nil {
+		return "", err
+	}
+	v, err := dockerCli.ServerVersion(ctx)
+	if err != nil {
+		return "", fmt.Errorf("unable to reach docker daemon at %s: %w", dockerCli.DaemonHost(), err)
+	}
+	return fmt.Sprintf("(version %s, %s/%s)", v.Version, v.Os, v.Arch), nil
+}
+
+func checkLogsDir(_ context.Context) (string, error) {
+	if err := os.MkdirAll(droneCILogsDir, 0o755); err != nil {
+		return "", err
+	}
+	f, err := os.CreateTemp(droneCILogsDir, ".doctor-")
+	if err != nil {
+		return "", err
+	}
+	f.Close()
+	return fmt.Sprintf("(%s)", droneCILogsDir), os.Remove(f.Name())
+}
+
+func checkExtensionSocket(_ context.Context) (string, error) {
+	home, err := os.UserHomeDir()
+	if err != nil {
+		return "", err
+	}
+	sock := path.Join(home, darwinExtensionSocketPath)
+	if _, err := os.Stat(sock); err != nil {
+		return "", fmt.Errorf("is the Drone CI extension installed? %w", err)
+	}
+	return fmt.Sprintf("(%s)", sock), nil
+}
+
+func checkPull(ctx context.Context, image, registryFile string, out io.Writer) (string, error) {
+	if dockerCli == nil {
+		return "", fmt.Errorf("docker is not reachable")
+	}
+	auth, err := registryAuth(registryFile, image)
+	if err != nil {
+		return "", err
+	}
+	return "", utils.EnsureImageWithAuth(ctx, dockerCli, image, auth, out)
+}
@@ -2,8 +2,10 @@ package drone
 
 import (
 	"os"
+	"strconv"
 	"strings"
 
+	"github.com/drone/drone-go/drone"
 	"github.com/urfave/cli/v2"
 )
 
@@ -17,8 +19,8 @@ func getEnv(c *cli.Context) map[string]string {
 		env["DRONE_COMMIT_BRANCH"] = v
 		env["DRONE_TARGET_BRANCH"] = v
 	}
-	if c.IsSet("event") {
-		v := c.String("event")
+	if c.IsSet("build-event") {
+		v := c.String("build-event")
 		env["DRONE_EVENT"] = v
 	}
 	if c.IsSet("instance") {
@@ -63,3 +65,55 @@ func prefixedEnviron(environ []string) map[string]string {
 	}
 	return envs
 }
+
+// invocationEnvironment returns the environment variables with one of the
+// allowed prefixes to record in the provenance, the values of the secrets
+// and of the credentials are redacted.
+func invocationEnvironment(envs map[string]string, prefixes []string, r *redactor) map[string]string {
+	recorded := map[string]string{}
+	for k, v := range envs {
+		if !hasAnyPrefix(k, prefixes) {
+			continue
+		}
+		recorded[k] = r.redact(k, v)
+	}
+	return recorded
+}
+
+// helper function returns true if s has any of the prefixes.
+func hasAnyPrefix(s string, prefixes []string) bool {
+	for _, p := range prefixes {
+		if strings.HasPrefix(s, p) {
+			return true
+		}
+	}
+	return false
+}
+
+// invocationParameters returns the build parameters and the build metadata
+// set from the command line that the when conditions of the steps match
+func invocationParameters(build *drone.Build, branch string) map[string]string {
+	params := map[string]string{}
+	for k, v := range build.Params {
+		params[k] = v
+	}
+	if build.Number != 0 {
+		params["build.number"] = strconv.FormatInt(build.Number, 10)
+	}
+	if build.Event != "" {
+		params["build.event"] = build.Event
+	}
+	if build.Source != "" {
+		params["source.branch"] = build.Source
+		params["target.branch"] = build.Target
+	} else if branch != "" {
+		params["branch"] = branch
+	}
+	if strings.HasPrefix(build.Ref, "refs/tags/") {
+		params["tag"] = strings.TrimPrefix(build.Ref, "refs/tags/")
+	}
+	if len(params) == 0 {
+		return nil
+	}
+	return params
+}
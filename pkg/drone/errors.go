@@ -0,0 +1,54 @@
+package drone
+
+import "errors"
+
+// errors returned by the commands, callers can match them with errors.Is
+// e.g. to map them to exit codes
+var (
+	// ErrStageNotFound is returned when the pipeline to run is not found in the build file
+	ErrStageNotFound = errors.New("stage not found")
+	// ErrLint is returned when the pipeline breaks the linting rules
+	ErrLint = errors.New("lint failed")
+	// ErrDockerUnavailable is returned when the docker daemon is not reachable
+	ErrDockerUnavailable = errors.New("docker unavailable")
+	// ErrBuildFailed is returned when a pipeline step failed, errored or was killed
+	ErrBuildFailed = errors.New("build failed")
+	// ErrMissingDigest is returned when the digest of a provenance material can not be resolved
+	ErrMissingDigest = errors.New("missing material digest")
+	// ErrEmptyRun is returned when all the steps are skipped and no step runs
+	ErrEmptyRun = errors.New("no steps to run")
+)
+
+// exitEmptyRun is the exit code of a run where all the steps are skipped,
+// distinct from the exit code 1 of the failed runs
+const exitEmptyRun = 3
+
+// kindError tags an error with one of the exported errors while
+// keeping the message and the chain of the underlying error
+type kindError struct {
+	kind error
+	err  error
+}
+
+// Error implements error
+func (e *kindError) Error() string {
+	return e.err.Error()
+}
+
+// Unwrap returns the underlying error
+func (e *kindError) Unwrap() error {
+	return e.err
+}
+
+// Is reports whether the error is of the kind target
+func (e *kindError) Is(target error) bool {
+	return target == e.kind
+}
+
+// withKind tags the error with the kind, nil errors are returned as is
+func withKind(kind, err error) error {
+	if err == nil {
+		return nil
+	}
+	return &kindError{kind: kind, err: err}
+}
@@ -0,0 +1,173 @@
+package drone
+
+import (
+	"context"
+	"encoding/json"
+	"io"
+	"sync"
+	"time"
+
+	"github.com/drone/drone-go/drone"
+	"github.com/drone/runner-go/pipeline"
+)
+
+// event types of the --events stream
+const (
+	eventStepStart = "step_start"
+	eventLogLine   = "log_line"
+	eventStepEnd   = "step_end"
+	eventBuildEnd  = "build_end"
+)
+
+// event is a newline delimited JSON record of the --events stream, e.g.
+//
+//	{"type":"step_start","time":"2022-12-01T10:00:00Z","step":"build","number":2}
+//	{"type":"log_line","time":"2022-12-01T10:00:01Z","step":"build","number":2,"line":"go build ./..."}
+//	{"type":"step_end","time":"2022-12-01T10:00:09Z","step":"build","number":2,"status":"success","exitCode":0}
+//	{"type":"build_end","time":"2022-12-01T10:00:09Z","status":"success"}
+type event struct {
+	Type     string `json:"type"`
+	Time     string `json:"time"`
+	Step     string `json:"step,omitempty"`
+	Number   int    `json:"number,omitempty"`
+	Line     string `json:"line,omitempty"`
+	Status   string `json:"status,omitempty"`
+	ExitCode *int   `json:"exitCode,omitempty"`
+	Error    string `json:"error,omitempty"`
+}
+
+// eventWriter is both a pipeline reporter and streamer writing the step
+// transitions and log lines as events, as they happen.
+type eventWriter struct {
+	sync.Mutex
+	enc *json.Encoder
+}
+
+var (
+	_ pipeline.Reporter = (*eventWriter)(nil)
+	_ pipeline.Streamer = (*eventWriter)(nil)
+)
+
+func newEventWriter(out io.Writer) *eventWriter {
+	return &eventWriter{enc: json.NewEncoder(out)}
+}
+
+// write writes the event with the current time
+func (e *eventWriter) write(ev event) error {
+	ev.Time = time.Now().UTC().Format(time.RFC3339Nano)
+	e.Lock()
+	defer e.Unlock()
+	return e.enc.Encode(ev)
+}
+
+// ReportStage implements pipeline.Reporter
+func (e *eventWriter) ReportStage(_ context.Context, state *pipeline.State) error {
+	state.Lock()
+	ev := event{
+		Type:   eventBuildEnd,
+		Status: state.Stage.Status,
+		Error:  state.Stage.Error,
+	}
+	state.Unlock()
+	if ev.Status == drone.StatusPending || ev.Status == drone.StatusRunning {
+		return nil
+	}
+	return e.write(ev)
+}
+
+// ReportStep implements pipeline.Reporter
+func (e *eventWriter) ReportStep(_ context.Context, state *pipeline.State, name string) error {
+	state.Lock()
+	var ev event
+	for _, s := range state.Stage.Steps {
+		if s.Name != name {
+			continue
+		}
+		ev = event{
+			Type:   eventStepEnd,
+			Step:   s.Name,
+			Number: s.Number,
+			Status: s.Status,
+			Error:  s.Error,
+		}
+		exitCode := s.ExitCode
+		ev.ExitCode = &exitCode
+		break
+	}
+	state.Unlock()
+	switch ev.Status {
+	case "", drone.StatusPending:
+		return nil
+	case drone.StatusRunning:
+		ev.Type, ev.Status, ev.ExitCode = eventStepStart, "", nil
+	}
+	return e.write(ev)
+}
+
+// Stream implements pipeline.Streamer
+func (e *eventWriter) Stream(_ context.Context, state *pipeline.State, name string) io.WriteCloser {
+	state.Lock()
+	defer state.Unlock()
+	w := &eventLogger{events: e, name: name}
+	for _, s := range state.Stage.Steps {
+		if s.Name == name {
+			w.number = s.Number
+			break
+		}
+	}
+	return w
+}
+
+// eventLogger writes the log lines of a step as events
+type eventLogger struct {
+	events *eventWriter
+	name   string
+	number int
+}
+
+// Write implements io.WriteCloser
+func (l *eventLogger) Write(b []byte) (int, error) {
+	for _, part := range split(b) {
+		if err := l.events.write(event{
+			Type:   eventLogLine,
+			Step:   l.name,
+			Number: l.number,
+			Line:   part,
+		}); err != nil {
+			return len(b), err
+		}
+	}
+	return len(b), nil
+}
+
+// Close implements io.WriteCloser
+func (l *eventLogger) Close() error {
+	return nil
+}
+
+// teeReporter reports the pipeline transitions to all of its reporters
+type teeReporter []pipeline.Reporter
+
+var _ pipeline.Reporter = (teeReporter)(nil)
+
+// ReportStage implements pipeline.Reporter
+func (t teeReporter) ReportStage(ctx context.Context, state *pipeline.State) error {
+	var result error
+	for _, r := range t {
+		if err := r.ReportStage(ctx, state); err != nil && result == nil {
+			result = err
+		}
+	}
+	return result
+}
+
+// ReportStep implements pipeline.Reporter
+func (t teeReporter) ReportStep(ctx context.Context, state *pipeline.State, name string) error {
+	var result error
+	for _, r := range t {
+		if err := r.ReportStep(ctx, state, name); err != nil && result == nil {
+			result = err
+		}
+	}
+	return result
+}
@@ -2,11 +2,16 @@ package drone
 
 import (
 	"context"
+	"crypto/sha256"
+	"encoding/hex"
 	"encoding/json"
+	"errors"
 	"fmt"
-	"io/ioutil"
+	"io"
+	"net/url"
 	"os"
 	"path"
+	"sort"
 	"strconv"
 	"strings"
 	"time"
@@ -14,21 +19,16 @@ import (
 	"github.com/docker/docker/client"
 	"github.com/drone-runners/drone-runner-docker/engine"
 	"github.com/drone-runners/drone-runner-docker/engine/compiler"
-	"github.com/drone-runners/drone-runner-docker/engine/linter"
 	"github.com/drone-runners/drone-runner-docker/engine/resource"
-	"github.com/google/go-containerregistry/pkg/crane"
 	"github.com/kameshsampath/drone-provenance/pkg/utils"
 
 	"github.com/drone/drone-go/drone"
-	"github.com/drone/envsubst"
 	"github.com/drone/runner-go/environ"
 	"github.com/drone/runner-go/environ/provider"
 	"github.com/drone/runner-go/labels"
 	"github.com/drone/runner-go/logger"
-	"github.com/drone/runner-go/manifest"
 	"github.com/drone/runner-go/pipeline"
 	"github.com/drone/runner-go/pipeline/runtime"
-	"github.com/drone/runner-go/pipeline/streamer/console"
 	"github.com/drone/runner-go/registry"
 	"github.com/drone/runner-go/secret"
 	"github.com/drone/signal"
@@ -37,7 +37,6 @@ import (
 	"github.com/urfave/cli/v2"
 
 	intoto "github.com/in-toto/in-toto-golang/in_toto"
-	"github.com/in-toto/in-toto-golang/in_toto/slsa_provenance/common"
 	slsa "github.com/in-toto/in-toto-golang/in_toto/slsa_provenance/v0.2"
 )
 
@@ -57,6 +56,8 @@ const (
 	labelStepNumber = "io.drone.step.number"
 	// labelService to identify if the step is a "Service"
 	labelService = "io.drone.desktop.pipeline.service"
+	// labelUIRefresh is to notify the extension UI to reload the pipelines
+	labelUIRefresh = "io.drone.desktop.ui.refresh"
 )
 
 var (
@@ -67,16 +68,27 @@ var (
 	dockerCli      *client.Client
 )
 
+func init() {
+	home, _ := os.UserHomeDir()
+	droneCIHome = utils.LookupEnvOrString("DRONE_CI_HOME", path.Join(home, ".drone-ci"))
+	droneCILogsDir = path.Join(droneCIHome, "logs")
+}
+
 // Command exports the exec command.
 var Command = &cli.Command{
 	Name:      "exec",
 	Usage:     "execute a local build",
-	ArgsUsage: "[path/to/.drone.yml]",
+	ArgsUsage: "[path/to/.drone.yml|-]",
 	Before: func(ctx *cli.Context) error {
 		return nil
 	},
 	Action: func(ctx *cli.Context) error {
 		if err := exec(ctx); err != nil {
+			// a run without steps exits with its own code, not the code of the failed runs
+			if errors.Is(err, ErrEmptyRun) {
+				log.Errorln(err)
+				os.Exit(exitEmptyRun)
+			}
 			log.Fatalln(err)
 		}
 		return nil
@@ -94,26 +106,218 @@ var Command = &cli.Command{
 			Name:  "exclude",
 			Usage: "Name of steps to exclude",
 		},
+		&cli.StringFlag{
+			Name:  "include-file",
+			Usage: "File with the name of steps to include, one per line",
+		},
+		&cli.StringFlag{
+			Name:  "exclude-file",
+			Usage: "File with the name of steps to exclude, one per line",
+		},
+		&cli.StringFlag{
+			Name:  "since",
+			Usage: "git ref, only the steps whose when paths condition matches a file changed since the ref are run",
+		},
 		&cli.StringFlag{
 			Name:  "resume-at",
 			Usage: "Name of start to resume at",
 		},
+		&cli.BoolFlag{
+			Name:  "clone",
+			Usage: "clone the repository with the clone step, authenticated with the netrc credentials, instead of mounting the current directory",
+		},
+		&cli.StringFlag{
+			Name:  "remote-url",
+			Usage: "URL of the repository cloned with --clone, defaults to the origin remote of the current directory",
+		},
+		&cli.BoolFlag{
+			Name:  "no-clone",
+			Usage: "do not run the clone step",
+		},
+		&cli.BoolFlag{
+			Name:  "fail-fast",
+			Usage: "cancel the running steps as soon as a step fails",
+		},
+		&cli.BoolFlag{
+			Name:  "keep-going",
+			Usage: "let the running steps finish when a step fails (default)",
+		},
+		&cli.StringFlag{
+			Name:  "dump-spec",
+			Usage: "file to write the compiled pipeline to, as JSON with the secrets redacted, before running it",
+		},
+		&cli.BoolFlag{
+			Name:  "no-color",
+			Usage: "disable the colored output, also disabled when NO_COLOR is set or the output is not a terminal",
+		},
+		&cli.BoolFlag{
+			Name:  "step-command-hashes",
+			Usage: "record the sha256 of the commands, or the settings of plugin steps, of each step in the provenance build config",
+		},
+		&cli.StringFlag{
+			Name:  "http-proxy",
+			Usage: "proxy of the http registry calls resolving the image digests, instead of HTTP_PROXY",
+		},
+		&cli.StringFlag{
+			Name:  "https-proxy",
+			Usage: "proxy of the https registry calls resolving the image digests, instead of HTTPS_PROXY",
+		},
+		&cli.StringFlag{
+			Name:  "no-proxy",
+			Usage: "comma separated hosts the registry calls do not use the proxy for, instead of NO_PROXY",
+		},
+		&cli.StringFlag{
+			Name:  "registry-mirror",
+			Usage: "registry mirror to pull the Docker Hub images from e.g. https://mirror.gcr.io",
+		},
+		&cli.BoolFlag{
+			Name:  "hide-pull",
+			Usage: "hide the image pull output of the docker engine",
+		},
+		&cli.BoolFlag{
+			Name:  "plan",
+			Usage: "print the steps that will run, with their image and run policy, before running them",
+		},
+		&cli.StringFlag{
+			Name:  "events",
+			Usage: "file or named pipe to write the newline delimited JSON events of the run to, - for stdout",
+		},
+		&cli.BoolFlag{
+			Name:  "timings",
+			Usage: "print a table of the duration of each step at the end of the run",
+		},
+		&cli.StringFlag{
+			Name:  "junit-file",
+			Usage: "file to write a JUnit report of the step results to",
+		},
+		&cli.StringFlag{
+			Name:  "trace-file",
+			Usage: "file to write the trace logs to, the console logs stay at the normal level",
+		},
+		&cli.BoolFlag{
+			Name:  "lint-strict",
+			Usage: "treat lint warnings as errors",
+		},
+		failOnLintRuleFlag(),
+		&cli.BoolFlag{
+			Name:  "skip-lint",
+			Usage: "do not lint the pipeline",
+		},
+		&cli.BoolFlag{
+			Name:  "keep-workspace",
+			Usage: "keep the workspace volume after the run for inspection, remove it with docker volume rm",
+		},
+		&cli.BoolFlag{
+			Name:  "keep-containers",
+			Usage: "keep the step containers after the run for inspection, remove them with docker rm -f",
+		},
+		&cli.BoolFlag{
+			Name:    "ui-refresh",
+			Usage:   "notify the Drone CI extension UI to reload the pipeline once the run is done, the refresh image is pulled with the --registry credentials",
+			EnvVars: []string{"DRONE_UI_REFRESH"},
+		},
+		&cli.BoolFlag{
+			Name:  "strict-logging",
+			Usage: "fail the run when the log file can not be written, instead of logging to the console only",
+		},
+		&cli.BoolFlag{
+			Name:  "auto-prune",
+			Usage: "after the run, remove the files of the runs older than " + defaultPruneOlderThan + " like the prune command",
+		},
+		&cli.BoolFlag{
+			Name:  "allow-empty-run",
+			Usage: "only warn, instead of failing, when all the steps are skipped and no step runs",
+		},
+		&cli.BoolFlag{
+			Name:  "halt-on-service-failure",
+			Usage: "fail the build as soon as a service exits non-zero or fails its health check",
+		},
+		&cli.BoolFlag{
+			Name:  "no-compile-cache",
+			Usage: "always compile the pipeline, do not use the compiled pipelines cached in $DRONE_CI_HOME/cache",
+		},
+		&cli.BoolFlag{
+			Name:  "progress",
+			Usage: "print the step progress to stderr",
+		},
+		&cli.IntFlag{
+			Name:  "pipeline-index",
+			Usage: "position of the pipeline to run in the build file, starting at 1",
+		},
+		&cli.Int64Flag{
+			Name:  "build-number",
+			Usage: "build number, DRONE_BUILD_NUMBER",
+		},
+		&cli.StringFlag{
+			Name:    "build-event",
+			Aliases: []string{"event"},
+			Usage:   "build event the when conditions match e.g. push, pull_request or tag",
+		},
+		&cli.StringFlag{
+			Name:  "branch",
+			Usage: "branch of the build the when conditions match",
+		},
+		&cli.StringFlag{
+			Name:  "source-branch",
+			Usage: "source branch of the pull request, the build event defaults to pull_request",
+		},
+		&cli.StringFlag{
+			Name:  "target-branch",
+			Usage: "target branch of the pull request the branch conditions match, defaults to --branch",
+		},
+		&cli.StringFlag{
+			Name:  "tag",
+			Usage: "tag of the build the when conditions match, the build event defaults to tag",
+		},
+		&cli.BoolFlag{
+			Name:  "release",
+			Usage: "record the build as a release in the provenance, with the tag of --release-tag, --tag or of the commit",
+		},
+		&cli.StringFlag{
+			Name:  "release-tag",
+			Usage: "tag of the release recorded in the provenance, overrides the tag of the build and of the commit",
+		},
+		&cli.StringFlag{
+			Name:  "netrc-machine",
+			Usage: "machine of the netrc credentials used to clone the repository e.g. github.com",
+		},
+		&cli.StringFlag{
+			Name:  "netrc-username",
+			Usage: "username of the netrc credentials",
+		},
+		&cli.StringFlag{
+			Name:    "netrc-password",
+			Usage:   "password or token of the netrc credentials",
+			EnvVars: []string{"DRONE_NETRC_PASSWORD"},
+		},
+		&cli.StringFlag{
+			Name:  "netrc-file",
+			Usage: "netrc file to read the credentials of --netrc-machine from, the netrc flags take precedence",
+		},
 		&cli.BoolFlag{
 			Name:  "trusted",
 			Usage: "build is trusted",
 		},
 		&cli.DurationFlag{
 			Name:  "timeout",
-			Usage: "build timeout",
+			Usage: "build timeout, rounded up to the minute",
 			Value: time.Hour,
 		},
+		&cli.DurationFlag{
+			Name:  "timeout-grace",
+			Usage: "grace period of the running steps to shut down on SIGTERM when the build times out, before they are force-removed",
+		},
 		&cli.StringSliceFlag{
 			Name:  "volume",
-			Usage: "build volumes",
+			Usage: "build volumes, the variables e.g. ${HOME} are expanded like in the pipeline source, falling back to the environment",
+		},
+		&cli.BoolFlag{
+			Name:  "create-volume-dirs",
+			Usage: "create the missing host directories of the volumes",
 		},
 		&cli.StringSliceFlag{
 			Name:  "network",
-			Usage: "external networks",
+			Usage: "external networks, the variables are expanded like the volumes",
 		},
 		&cli.StringFlag{
 			Name:  "registry",
@@ -128,6 +332,41 @@ var Command = &cli.Command{
 			Name:  "env-file",
 			Usage: "env file",
 		},
+		&cli.StringSliceFlag{
+			Name:  "env-allowlist-prefix",
+			Usage: "prefix of the environment variables recorded in the provenance",
+			Value: cli.NewStringSlice("DRONE_"),
+		},
+		&cli.StringSliceFlag{
+			Name:  "image-override",
+			Usage: "substitute a step image of the form image=replacement e.g. node:18=myrepo/node:18-patched",
+		},
+		&cli.StringSliceFlag{
+			Name:  "step-env",
+			Usage: "environment variable of a single step of the form step:KEY=VALUE",
+		},
+		&cli.StringFlag{
+			Name:    "select",
+			Aliases: []string{"label-selector"},
+			Usage:   "run only the steps whose labels match the selector e.g. 'io.drone.desktop.pipeline.service!=true'",
+		},
+		&cli.StringSliceFlag{
+			Name:  "step-retries",
+			Usage: "times a failed step is run again before it fails, N for all the steps or step:N for a single step",
+		},
+		&cli.StringSliceFlag{
+			Name:    "add-host",
+			Aliases: []string{"extra-hosts"},
+			Usage:   "host entry of the form name:ip to add to /etc/hosts of the step containers",
+		},
+		&cli.StringFlag{
+			Name:  "pipeline-file",
+			Usage: "pipeline file path to set on the io.drone.desktop.pipeline.file label of the step containers, defaults to the absolute path of the pipeline file",
+		},
+		&cli.StringSliceFlag{
+			Name:  "label",
+			Usage: "custom label of the form key=value added to all the step containers",
+		},
 		&cli.StringSliceFlag{
 			Name:  "privileged",
 			Usage: "privileged plugins",
@@ -139,74 +378,466 @@ var Command = &cli.Command{
 				"plugins/heroku",
 			),
 		},
+		&cli.StringSliceFlag{
+			Name:  "subject",
+			Usage: "additional provenance subject of the form name=sha256:digest",
+		},
+		&cli.StringFlag{
+			Name:  "lockfile",
+			Usage: "lockfile of the workspace, e.g. go.sum or package-lock.json, whose dependencies are recorded as provenance materials",
+		},
+		&cli.StringFlag{
+			Name:  "lockfile-type",
+			Usage: "type of the lockfile, one of auto, gosum or npm",
+			Value: lockfileAuto,
+		},
+		&cli.StringSliceFlag{
+			Name:  "subject-glob",
+			Usage: "glob pattern of the workspace files to add as provenance subjects e.g. dist/*.tar.gz",
+		},
+		&cli.StringFlag{
+			Name:  "subjects-file",
+			Usage: "file with additional provenance subjects, one name=sha256:digest per line",
+		},
+		&cli.StringFlag{
+			Name:  "provenance-subject-from",
+			Usage: "image reference pushed by the pipeline e.g. registry/app:${DRONE_TAG}, its digest is set as the only provenance subject",
+		},
+		&cli.BoolFlag{
+			Name:  "exclude-clone-material",
+			Usage: "do not record the clone step image as a provenance material",
+		},
+		&cli.BoolFlag{
+			Name:  "verify-images",
+			Usage: "verify the cosign signature of the step images before running the pipeline",
+		},
+		&cli.StringFlag{
+			Name:  "verify-key",
+			Usage: "PEM encoded public key verifying the step image signatures with --verify-images",
+		},
+		&cli.StringSliceFlag{
+			Name:  "verify-exempt",
+			Usage: "image or repository whose signature is not verified with --verify-images",
+		},
+		&cli.StringFlag{
+			Name:  "materials-file",
+			Usage: "write the image materials as a json list of image, digest and step to the file",
+		},
+		&cli.BoolFlag{
+			Name:  "include-service-materials",
+			Usage: "record the service images as provenance materials, by default only the step images are recorded",
+		},
+		&cli.StringFlag{
+			Name:  "attestation-type",
+			Usage: "type of attestation to generate, one of provenance or link",
+			Value: attestationProvenance,
+		},
+		&cli.StringFlag{
+			Name:  "material-uri-scheme",
+			Usage: "URI scheme of the image materials, one of pkg, purl or oci",
+			Value: materialSchemePkg,
+		},
+		&cli.StringFlag{
+			Name:  "provenance-format",
+			Usage: "format of the provenance, one of in-toto or cyclonedx",
+			Value: formatInToto,
+		},
+		&cli.StringFlag{
+			Name:  "statement-type",
+			Usage: "in-toto statement type URI of the attestation",
+			Value: intoto.StatementInTotoV01,
+		},
+		&cli.BoolFlag{
+			Name:  "readonly-source",
+			Usage: "mount the source read-only and provide a writable volume at " + outputVolumePath,
+		},
+		&cli.StringFlag{
+			Name:  "output-dir",
+			Usage: "directory to write the provenance, logs and run summary to",
+		},
+		&cli.StringFlag{
+			Name:  "log-format",
+			Usage: "format of the step log file, one of json, plain for '<timestamp> [step] line' text or console to write no log file; the stdout and stderr of the steps are combined",
+			Value: logFormatJSON,
+		},
+		&cli.StringFlag{
+			Name:  "max-log-size",
+			Usage: "rotate the step log file once it exceeds this size e.g. 10MB, 0 disables rotation",
+			Value: "0",
+		},
+		&cli.DurationFlag{
+			Name:  "provenance-timeout",
+			Usage: "provenance generation timeout",
+			Value: time.Minute,
+		},
+		&cli.BoolFlag{
+			Name:  "offline",
+			Usage: "resolve the image digests from the local docker daemon only, never reaching the registries",
+		},
+		&cli.StringFlag{
+			Name:  "predicate-extra-file",
+			Usage: "json file with org specific fields to add to the provenance predicate under its extensions field",
+		},
+		&cli.StringFlag{
+			Name:    "previous-provenance",
+			Aliases: []string{"baseline-provenance"},
+			Usage:   "provenance of a previous run to annotate the materials as added, changed or unchanged",
+		},
+		&cli.BoolFlag{
+			Name:  "only-changed-images",
+			Usage: "record only the images added or changed since --previous-provenance, which references the unchanged images",
+		},
+		&cli.BoolFlag{
+			Name:  "provenance-on-cancel",
+			Usage: "generate the provenance of the completed steps when the run is cancelled",
+		},
+		&cli.BoolFlag{
+			Name:  "provenance-gzip",
+			Usage: "gzip compress the provenance file",
+		},
+		&cli.BoolFlag{
+			Name:  "provenance-checksum",
+			Usage: "write the sha256 checksum of the provenance file to <file>.sha256",
+		},
+		&cli.StringFlag{
+			Name:  "provenance-name-template",
+			Usage: "go template of the provenance file name with {{.Stage}}, {{.BuildID}}, {{.Timestamp}} and {{.Source}} e.g. {{.Stage}}-{{.Timestamp}}.provenance.json",
+		},
+		&cli.BoolFlag{
+			Name:  "provenance-indent",
+			Usage: "indent the provenance json written to the file or stdout, --provenance-indent=false minifies it",
+			Value: true,
+		},
+		&cli.BoolFlag{
+			Name:  "predicate-only",
+			Usage: "write only the provenance predicate, without the in-toto statement, e.g. for cosign attest --predicate",
+		},
+		&cli.BoolFlag{
+			Name:  "fail-on-missing-digest",
+			Usage: "fail without writing the provenance when the digest of a material can not be resolved",
+		},
+		&cli.StringFlag{
+			Name:  "provenance-file",
+			Usage: "file to write the provenance to",
+		},
+		&cli.StringFlag{
+			Name:  "provenance-attach",
+			Usage: "image to attach the provenance to in its registry, tagged sha256-<digest>.att",
+		},
+		&cli.StringFlag{
+			Name:  "provenance-url",
+			Usage: "URL to post the provenance json to",
+		},
+		&cli.StringSliceFlag{
+			Name:  "provenance-header",
+			Usage: "header of the form 'Name: value' of the provenance post e.g. 'Authorization: Bearer <token>'",
+		},
+		&cli.StringFlag{
+			Name:    "provenance-token",
+			Usage:   "bearer token of the provenance post",
+			EnvVars: []string{"DRONE_PROVENANCE_TOKEN"},
+		},
+		&cli.BoolFlag{
+			Name:  "provenance-dsse",
+			Usage: "post the provenance wrapped in an unsigned DSSE envelope",
+		},
+		&cli.IntFlag{
+			Name:  "provenance-retries",
+			Usage: "number of times the provenance post is retried on server errors",
+			Value: 3,
+		},
+		&cli.StringFlag{
+			Name:  "context",
+			Usage: "name of the docker context to run the pipeline with, instead of the docker environment variables",
+		},
+		&cli.BoolFlag{
+			Name:  "provenance-stdout",
+			Usage: "write the provenance to stdout, the logs are written to stderr",
+		},
+		&cli.BoolFlag{
+			Name:  "sign",
+			Usage: "sign the provenance keyless with a Fulcio certificate and record the signature in Rekor, the provenance is written as a sigstore bundle",
+		},
+		&cli.StringFlag{
+			Name:    "identity-token",
+			Usage:   "OIDC identity token the Fulcio signing certificate is issued for",
+			EnvVars: []string{"SIGSTORE_ID_TOKEN"},
+		},
+		&cli.StringFlag{
+			Name:  "fulcio-url",
+			Usage: "URL of the Fulcio instance issuing the signing certificate",
+			Value: defaultFulcioURL,
+		},
+		&cli.StringFlag{
+			Name:  "rekor-url",
+			Usage: "URL of the Rekor transparency log recording the signature",
+			Value: defaultRekorURL,
+		},
 	},
 }
 
+// execOptions are the internal options of the exec flow, they are not
+// exposed by the CLI
+type execOptions struct {
+	// newEngine creates the pipeline engine instead of the docker engine,
+	// e.g. a fake engine that runs the pipeline without docker in tests.
+	// The docker client is then not created.
+	newEngine func(opts engine.Opts) (runtime.Engine, error)
+}
+
 func exec(cliContext *cli.Context) error {
+	return execWith(cliContext, execOptions{})
+}
+
+// execWith runs the exec flow with the internal options
+func execWith(cliContext *cli.Context, opts execOptions) error {
 	var err error
-	dockerCli, err = utils.DockerCliClient()
-	if err != nil {
-		return err
+	if opts.newEngine == nil {
+		// the docker context must be in use before any docker client is created
+		if err := utils.UseDockerContext(cliContext.String("context")); err != nil {
+			return withKind(ErrDockerUnavailable, err)
+		}
+		dockerCli, err = utils.DockerCliClient()
+		if err != nil {
+			return withKind(ErrDockerUnavailable, err)
+		}
+		// fail early with an actionable error instead of a
+		// cryptic one from the engine when docker is down.
+		pingCtx, pingCancel := context.WithTimeout(nocontext, 30*time.Second)
+		err = utils.PingDocker(pingCtx, dockerCli)
+		pingCancel()
+		if err != nil {
+			return withKind(ErrDockerUnavailable, err)
+		}
 	}
 	// lets do our mapping from CLI flags to an execCommand struct
-	commy := toExecCommand(cliContext)
-	rawsource, err := ioutil.ReadFile(commy.Source)
+	commy, err := toExecCommand(cliContext)
 	if err != nil {
 		return err
 	}
-	envs := environ.Combine(
-		getEnv(cliContext),
-		environ.System(commy.System),
-		environ.Repo(commy.Repo),
-		environ.Build(commy.Build),
-		environ.Stage(commy.Stage),
-		environ.Link(commy.Repo, commy.Build, commy.System),
-		commy.Build.Params,
-	)
-
-	// string substitution function ensures that string
-	// replacement variables are escaped and quoted if they
-	// contain newlines.
-	subf := func(k string) string {
-		v := envs[k]
-		if strings.Contains(v, "\n") {
-			v = fmt.Sprintf("%q", v)
+	if commy.NoColor {
+		utils.DisableColors(log)
+	}
+	if commy.HTTPProxy != "" || commy.HTTPSProxy != "" || commy.NoProxy != "" {
+		if registryTransport, err = newProxyTransport(commy.HTTPProxy, commy.HTTPSProxy, commy.NoProxy); err != nil {
+			return err
 		}
-		return v
 	}
-
-	// evaluates string replacement expressions and returns an
-	// update configuration.
-	config, err := envsubst.Eval(string(rawsource), subf)
+	// validate the explicit subjects upfront so that a typo
+	// does not surface only after the build has completed.
+	subjects, err := parseSubjects(commy.Subjects, commy.SubjectsFile)
 	if err != nil {
 		return err
 	}
-
+	if commy.SubjectFrom != "" && (len(subjects) > 0 || len(commy.SubjectGlobs) > 0) {
+		return fmt.Errorf("--provenance-subject-from can not be used with --subject, --subjects-file or --subject-glob")
+	}
+	if err := validateSubjectGlobs(commy.SubjectGlobs); err != nil {
+		return err
+	}
+	if len(commy.ImageOverrides) > 0 {
+		vctx, vcancel := context.WithTimeout(nocontext, commy.ProvenanceTimeout)
+		err = validateImageOverrides(vctx, commy.ImageOverrides, commy.Offline)
+		vcancel()
+		if err != nil {
+			return err
+		}
+	}
+	switch commy.AttestationType {
+	case attestationProvenance, attestationLink:
+	default:
+		return fmt.Errorf("unsupported attestation type '%s'", commy.AttestationType)
+	}
+	if err := validateStatementType(commy.StatementType); err != nil {
+		return err
+	}
+	if err := validateProvenanceFormat(commy.ProvenanceFormat); err != nil {
+		return err
+	}
+	if err := validateMaterialURIScheme(commy.MaterialURIScheme); err != nil {
+		return err
+	}
+	if commy.PredicateOnly {
+		if commy.AttestationType != attestationProvenance || commy.ProvenanceFormat != formatInToto {
+			return fmt.Errorf("--predicate-only requires the %s attestation in the %s format", attestationProvenance, formatInToto)
+		}
+		if commy.ProvenanceAttach != "" || commy.ProvenanceDSSE || commy.Sign {
+			return fmt.Errorf("--predicate-only can not be used with --provenance-attach, --provenance-dsse or --sign")
+		}
+	}
+	if commy.Lockfile != "" {
+		if _, err := lockfileType(commy.Lockfile, commy.LockfileType); err != nil {
+			return err
+		}
+	}
+	if commy.VerifyImages {
+		if commy.Offline {
+			return fmt.Errorf("--verify-images can not be used with --offline")
+		}
+		// keyless verification needs the fulcio roots and the rekor log, which are not supported
+		f := cliContext.String("verify-key")
+		if f == "" {
+			return fmt.Errorf("--verify-images requires --verify-key, keyless verification is not supported")
+		}
+		if commy.VerifyKey, err = readPublicKey(f); err != nil {
+			return err
+		}
+	}
+	if t := cliContext.String("provenance-name-template"); t != "" {
+		if commy.ProvenanceName, err = parseProvenanceNameTemplate(t); err != nil {
+			return err
+		}
+	}
+	if commy.Offline && (commy.ProvenanceAttach != "" || commy.ProvenanceURL != "") {
+		return fmt.Errorf("--provenance-attach and --provenance-url can not be used with --offline")
+	}
+	if u, err := url.Parse(commy.ProvenanceURL); commy.ProvenanceURL != "" && (err != nil || (u.Scheme != "http" && u.Scheme != "https") || u.Host == "") {
+		return fmt.Errorf("invalid --provenance-url '%s', expecting an http(s) URL", commy.ProvenanceURL)
+	}
+	if commy.Sign {
+		if commy.Offline {
+			return fmt.Errorf("--sign can not be used with --offline")
+		}
+		if commy.ProvenanceDSSE {
+			return fmt.Errorf("--sign and --provenance-dsse are mutually exclusive")
+		}
+		if commy.IdentityToken == "" {
+			return fmt.Errorf("--sign requires an --identity-token")
+		}
+		if err := validateServiceURL("fulcio-url", commy.FulcioURL); err != nil {
+			return err
+		}
+		if err := validateServiceURL("rekor-url", commy.RekorURL); err != nil {
+			return err
+		}
+	}
+	if f := cliContext.String("predicate-extra-file"); f != "" {
+		if commy.PredicateExtra, err = readPredicateExtra(f); err != nil {
+			return err
+		}
+	}
+	switch commy.LogFormat {
+	case logFormatConsole, logFormatJSON, logFormatPlain:
+	default:
+		return fmt.Errorf("unsupported log format '%s', expecting one of [%s %s %s]", commy.LogFormat, logFormatConsole, logFormatJSON, logFormatPlain)
+	}
+	if commy.MaxLogSize < 0 {
+		return fmt.Errorf("invalid --max-log-size '%s'", cliContext.String("max-log-size"))
+	}
+	if commy.TimeoutGrace < 0 {
+		return fmt.Errorf("invalid --timeout-grace '%s'", commy.TimeoutGrace)
+	}
+	if commy.ProvenanceFile != "" && commy.ProvenanceStdout {
+		return fmt.Errorf("--provenance-file and --provenance-stdout are mutually exclusive")
+	}
+	if commy.Events == stdoutPath && commy.ProvenanceStdout {
+		return fmt.Errorf("--events - and --provenance-stdout are mutually exclusive")
+	}
+	if commy.ProvenanceStdout || commy.Events == stdoutPath {
+		// stdout is kept for the provenance or the events, the logs are
+		// written to the console writer i.e. stderr.
+		log.SetOutput(commy.Console)
+	}
+	if commy.SkipLint && commy.LintStrict {
+		return fmt.Errorf("--skip-lint and --lint-strict are mutually exclusive")
+	}
+	if commy.LintStrict && len(commy.FailOnLintRules) > 0 {
+		return fmt.Errorf("--lint-strict and --fail-on-lint-rule are mutually exclusive")
+	}
+	if err := validateLintRules(commy.FailOnLintRules); err != nil {
+		return err
+	}
+	if commy.Release {
+		if commy.ReleaseTag, err = releaseTag(nocontext, commy.ReleaseTag, commy.Build.Ref); err != nil {
+			return err
+		}
+	}
+	if commy.OnlyChangedImages && commy.PreviousProvenance == "" {
+		return fmt.Errorf("--only-changed-images requires --previous-provenance")
+	}
+	if commy.Clone && commy.NoClone {
+		return fmt.Errorf("--clone and --no-clone are mutually exclusive")
+	}
+	if commy.Clone && commy.ReadonlySource {
+		return fmt.Errorf("--readonly-source can not be used with --clone, the source is not mounted")
+	}
+	if commy.Clone && commy.Repo.HTTPURL == "" {
+		if commy.Repo.HTTPURL, err = originRemoteURL(nocontext); err != nil {
+			return fmt.Errorf("--clone requires --remote-url, unable to default it to the origin remote: %w", err)
+		}
+		log.Infof("Cloning the origin remote %s", commy.Repo.HTTPURL)
+	}
+	if commy.FailFast && commy.KeepGoing {
+		return fmt.Errorf("--fail-fast and --keep-going are mutually exclusive")
+	}
 	// parse and lint the configuration.
-	manifest, err := manifest.ParseString(config)
+	manifest, err := parseManifest(cliContext, commy)
 	if err != nil {
 		return err
 	}
 
 	// a configuration can contain multiple pipelines.
 	// get a specific pipeline resource for execution.
-	if commy.Stage.Name == "" {
+	if commy.PipelineIndex > 0 && commy.Stage.Name != "" {
+		return fmt.Errorf("--pipeline and --pipeline-index are mutually exclusive")
+	}
+	if commy.PipelineIndex == 0 && commy.Stage.Name == "" && isSourceDir(commy.Source) {
+		files, _ := pipelineFiles(commy.Source)
+		return fmt.Errorf("directory %s has the pipeline files %v, select the pipeline to run with --pipeline or --pipeline-index", commy.Source, files)
+	}
+	if commy.PipelineIndex == 0 && commy.Stage.Name == "" {
 		log.Infoln("No stage specified, assuming 'default'")
 		commy.Stage.Name = "default"
 	}
 
-	res, err := resource.Lookup(commy.Stage.Name, manifest)
+	res, err := lookupPipeline(commy, manifest)
 	if err != nil {
-		return fmt.Errorf("stage '%s' not found in build file : %w", commy.Stage.Name, err)
+		return err
 	}
 
-	// lint the pipeline and return an error if any
-	// linting rules are broken
-	lint := linter.New()
-	err = lint.Lint(res, commy.Repo)
+	out, err := newOutputPaths(commy, commy.Stage.Name)
 	if err != nil {
 		return err
 	}
+	// the step logs are only written to the console
+	if commy.LogFormat == logFormatConsole {
+		out.Log = ""
+	}
+
+	// the trust of the repo is used by the linter to allow
+	// host volumes, devices and privileged steps.
+	if commy.Repo.Trusted {
+		log.Infoln("Running as a trusted build")
+	}
+
+	// lint the pipeline and return an error if any
+	// linting rules are broken
+	if commy.SkipLint {
+		log.Warnln("Lint mode: skip, the pipeline is not linted")
+	} else {
+		switch {
+		case commy.LintStrict:
+			log.Infoln("Lint mode: strict, lint warnings are treated as errors")
+		case len(commy.FailOnLintRules) > 0:
+			log.Infof("Lint mode: selected, only the rules %s fail the lint", strings.Join(commy.FailOnLintRules, ","))
+		default:
+			log.Debugln("Lint mode: default, lint warnings are logged")
+		}
+		if p, ok := res.(*resource.Pipeline); ok {
+			errs := 0
+			for _, f := range append(lintFindings(p, commy.Repo), lintWarnings(p)...) {
+				if lintFails(f, commy.LintStrict, commy.FailOnLintRules) {
+					log.Errorf("lint: %s (%s)", f.Message, f.Rule)
+					errs++
+				} else {
+					log.Warnf("lint: %s (%s)", f.Message, f.Rule)
+				}
+			}
+			if errs > 0 {
+				return withKind(ErrLint, fmt.Errorf("%d lint error(s) found", errs))
+			}
+		}
+	}
 
 	// compile the pipeline to an intermediate representation.
 	comp := &compiler.Compiler{
@@ -234,7 +865,15 @@ func exec(cliContext *cli.Context) error {
 		if comp.Labels == nil {
 			comp.Labels = make(map[string]string)
 		}
-		comp.Labels[labelPipelineFile] = path.Join(pwd, commy.Source)
+		switch {
+		case commy.PipelineFileLabel != "":
+			// the path of the pipeline file as known by the extension
+			comp.Labels[labelPipelineFile] = commy.PipelineFileLabel
+		case commy.Source == stdinSource:
+			comp.Labels[labelPipelineFile] = stdinSourceURI
+		default:
+			comp.Labels[labelPipelineFile] = path.Join(pwd, commy.Source)
+		}
 	}
 
 	args := runtime.CompilerArgs{
@@ -247,11 +886,57 @@ func exec(cliContext *cli.Context) error {
 		System:   commy.System,
 		Secret:   secret.StaticVars(commy.Secrets),
 	}
-	spec := comp.Compile(nocontext, args).(*engine.Spec)
+	// the compiled pipeline is cached, keyed on its inputs, to speed up the
+	// repeated runs of the same pipeline
+	var spec *engine.Spec
+	cacheKey := ""
+	if isCompileCacheable(commy) {
+		pwd, _ := os.Getwd()
+		if cacheKey, err = compileCacheKey(commy, pwd); err != nil {
+			log.Debugf("Not caching the compiled pipeline,%v", err)
+			cacheKey = ""
+		}
+		spec = readCachedSpec(cacheKey)
+	}
+	if spec != nil {
+		log.Infof("Using the cached compiled pipeline %s", cacheKey)
+	} else {
+		spec = comp.Compile(nocontext, args).(*engine.Spec)
+		if cacheKey != "" {
+			if err := writeCachedSpec(cacheKey, spec); err != nil {
+				log.Warnf("Unable to cache the compiled pipeline,%v", err)
+			}
+		}
+	}
+
+	if !commy.Clone && commy.ReadonlySource {
+		readonlySource(spec)
+	}
 
 	//Handle to parsed Pipeline
 	p := res.(*resource.Pipeline)
 
+	// substitute the step images, the original images
+	// are recorded in the provenance materials
+	commy.ImageOriginals = overrideImages(spec, commy.ImageOverrides)
+
+	// apply the step environment overrides
+	for name, envs := range commy.StepEnvs {
+		step := findStep(spec, name)
+		if step == nil {
+			return fmt.Errorf("--step-env step '%s' not found in stage '%s'", name, p.Name)
+		}
+		step.Envs = environ.Combine(step.Envs, envs)
+		log.Debugf("Step %s, environment overrides: %v", name, envs)
+	}
+
+	// add the host entries to all the step containers
+	if len(commy.ExtraHosts) > 0 {
+		for _, step := range spec.Steps {
+			step.ExtraHosts = append(step.ExtraHosts, commy.ExtraHosts...)
+		}
+	}
+
 	//As the Compiler does not add labels for Steps adding few here
 	for i, step := range spec.Steps {
 		extraLabels := map[string]string{}
@@ -278,11 +963,21 @@ func exec(cliContext *cli.Context) error {
 				break
 			}
 		}
-		step.Labels = labels.Combine(step.Labels, extraLabels)
+		// the custom labels never override the labels added by the runner
+		step.Labels = labels.Combine(commy.Labels, step.Labels, extraLabels)
 
 		log.Tracef("Step %s, Labels: %#v", step.Name, step.Labels)
 	}
 
+	// the compiler never runs the steps whose when
+	// condition does not match the build.
+	skipped := map[string]string{}
+	for _, step := range spec.Steps {
+		if step.RunPolicy == runtime.RunNever {
+			skipped[step.Name] = skipWhen
+		}
+	}
+
 	// include only steps that are in the include list,
 	// if the list in non-empty.
 	if len(commy.Include) > 0 {
@@ -296,7 +991,7 @@ func exec(cliContext *cli.Context) error {
 					continue I
 				}
 			}
-			step.RunPolicy = runtime.RunNever
+			skipStep(skipped, step, skipNotIncluded)
 		}
 	}
 	// exclude steps that are in the exclude list, if the list in non-empty.
@@ -308,13 +1003,33 @@ func exec(cliContext *cli.Context) error {
 			}
 			for _, name := range commy.Exclude {
 				if step.Name == name {
-					step.RunPolicy = runtime.RunNever
+					skipStep(skipped, step, skipExcluded)
 					continue E
 				}
 			}
 		}
 	}
-	// resume at a specific step
+	// run only the steps whose labels match the label selector.
+	if len(commy.Selector) > 0 {
+		for _, step := range spec.Steps {
+			if step.Name != "clone" && !commy.Selector.Matches(step.Labels) {
+				skipStep(skipped, step, skipSelector)
+			}
+		}
+	}
+	// skip the steps whose paths did not change since the git ref,
+	// all the steps run when the changed files can not be listed.
+	if commy.Since != "" {
+		changed, err := changedFiles(nocontext, commy.Since)
+		if err != nil {
+			log.Warnf("Unable to list the files changed since %s, running all the steps,%v", commy.Since, err)
+		} else {
+			for _, name := range unchangedSteps(p, spec, changed) {
+				skipStep(skipped, findStep(spec, name), skipSince)
+			}
+		}
+	}
+	// resume at a specific step, skipping all the steps before it
 	if cliContext.String("resume-at") != "" {
 		for _, step := range spec.Steps {
 			if step.Name == cliContext.String("resume-at") {
@@ -323,11 +1038,24 @@ func exec(cliContext *cli.Context) error {
 			if step.Name == "clone" {
 				continue
 			}
-			for _, name := range commy.Exclude {
-				if step.Name == name {
-					step.RunPolicy = runtime.RunNever
-					continue
-				}
+			skipStep(skipped, step, skipResumeAt)
+		}
+	}
+	// never run the clone step, this is applied after the include
+	// and exclude lists so that it cannot be enabled again.
+	if commy.NoClone {
+		for _, step := range spec.Steps {
+			if step.Name == "clone" {
+				skipStep(skipped, step, skipNoClone)
+			}
+		}
+	}
+	// a failing step cancels all the running steps, except the
+	// steps whose failures are ignored.
+	if commy.FailFast {
+		for _, step := range spec.Steps {
+			if step.ErrPolicy == runtime.ErrFail {
+				step.ErrPolicy = runtime.ErrFailFast
 			}
 		}
 	}
@@ -346,9 +1074,47 @@ func exec(cliContext *cli.Context) error {
 		})
 	}
 
-	// configures the pipeline timeout.
+	if commy.Plan {
+		if err := writePlan(commy.Console, spec); err != nil {
+			return err
+		}
+	}
+	if commy.DumpSpec != "" {
+		if err := writeJSON(commy.DumpSpec, redactedSpec(spec, commy.Redactor)); err != nil {
+			return fmt.Errorf("unable to dump the compiled pipeline: %w", err)
+		}
+		log.Infof("Compiled pipeline written to %s", commy.DumpSpec)
+	}
+	// a run without steps, e.g. all the steps are filtered out,
+	// is not reported as a success unless it is allowed. The
+	// clone step alone does not make a run.
+	runnable := 0
+	for _, step := range commy.Stage.Steps {
+		if step.Name != "clone" {
+			runnable++
+		}
+	}
+	if runnable == 0 {
+		if !commy.AllowEmptyRun {
+			return withKind(ErrEmptyRun, fmt.Errorf("no steps to run in stage '%s', all the steps are skipped by the filters or their when conditions", commy.Stage.Name))
+		}
+		log.Warnf("No steps ran in stage '%s', all the steps are skipped by the filters or their when conditions", commy.Stage.Name)
+	}
+
+	if commy.VerifyImages {
+		vctx, vcancel := context.WithTimeout(nocontext, commy.ProvenanceTimeout)
+		commy.ImageSignatures, err = verifyStepImages(vctx, spec, keyVerifier(commy.VerifyKey), commy.VerifyExempt, commy.RegistryMirror, commy.ImageOriginals)
+		vcancel()
+		if err != nil {
+			return fmt.Errorf("image signature verification failed, %w", err)
+		}
+	}
+
+	// configures the pipeline timeout, the running steps are
+	// force-removed once the grace period is over.
 	timeout := time.Duration(commy.Repo.Timeout) * time.Minute
-	ctx, cancel := context.WithTimeout(nocontext, timeout)
+	deadline := time.Now().Add(timeout)
+	ctx, cancel := context.WithTimeout(nocontext, timeout+commy.TimeoutGrace)
 	defer cancel()
 
 	// listen for operating system signals and cancel execution when received.
@@ -371,107 +1137,477 @@ func exec(cliContext *cli.Context) error {
 	if commy.Trace {
 		log.SetLevel(logrus.TraceLevel)
 	}
+	if commy.TraceFile != "" {
+		tf, err := utils.TraceFile(log, commy.TraceFile)
+		if err != nil {
+			return fmt.Errorf("unable to create trace file: %w", err)
+		}
+		defer tf.Close()
+	}
 	logger.Default = logger.Logrus(
 		logrus.NewEntry(
 			log,
 		),
 	)
 
-	engine, err := engine.NewEnv(engine.Opts{})
+	newEngine := opts.newEngine
+	if newEngine == nil {
+		newEngine = newDockerEngine
+	}
+	// HidePull is the only option of the docker engine
+	engine, err := newEngine(engine.Opts{
+		HidePull: commy.HidePull,
+	})
 	if err != nil {
 		return err
 	}
 
+	var eng runtime.Engine = engine
+	if commy.KeepWorkspace || commy.KeepContainers {
+		eng = &keepEngine{
+			Engine:     engine,
+			workspace:  commy.KeepWorkspace,
+			containers: commy.KeepContainers,
+		}
+	}
+	if commy.RegistryMirror != "" {
+		eng = &mirrorEngine{
+			Engine: eng,
+			mirror: commy.RegistryMirror,
+		}
+	}
+
+	var retry *retryEngine
+	if len(commy.StepRetries) > 0 {
+		retry = newRetryEngine(eng, commy.StepRetries, deadline)
+		eng = retry
+	}
+	var grace *graceEngine
+	if commy.TimeoutGrace > 0 {
+		grace = newGraceEngine(eng)
+		eng = grace
+		t := time.AfterFunc(timeout, func() {
+			log.Warnf("Build timed out after %s, stopping the running steps within %s", timeout, commy.TimeoutGrace)
+			grace.terminate(ctx)
+		})
+		defer t.Stop()
+	}
+	var services *serviceEngine
+	if commy.HaltOnServiceFailure {
+		services = &serviceEngine{
+			Engine: eng,
+			cancel: cancel,
+		}
+		eng = services
+	}
+
+	var streamer pipeline.Streamer = newConsoleStreamer(commy.Console, commy.Pretty)
+	if out.Log != "" {
+		// the run is not blocked by a log file that can not be
+		// written, unless logging is strict.
+		js, err := newStreamer(out.Log, commy.MaxLogSize, commy.LogFormat)
+		switch {
+		case err != nil && commy.StrictLogging:
+			return err
+		case err != nil:
+			log.Warnf("Unable to write the logs to %s, the logs are only written to the console,%v", out.Log, err)
+			out.Log = ""
+		default:
+			defer js.Close()
+			streamer = teeStreamer{streamer, js}
+		}
+	}
+
+	// the recorder is the source of the step statuses and durations
+	recorder := newStateRecorder()
+	reporters := teeReporter{recorder}
+	if commy.Progress {
+		reporters = append(reporters, newProgressReporter(commy.Stderr, commy.NoColor))
+	}
+	if commy.Events != "" {
+		w := commy.Stdout
+		if commy.Events != stdoutPath {
+			// the events file can also be a named pipe
+			f, err := os.OpenFile(commy.Events, os.O_WRONLY|os.O_CREATE|os.O_TRUNC, 0o644)
+			if err != nil {
+				return fmt.Errorf("unable to open the events file: %w", err)
+			}
+			defer f.Close()
+			w = f
+		}
+		events := newEventWriter(w)
+		reporters = append(reporters, events)
+		streamer = teeStreamer{streamer, events}
+	}
+
 	err = runtime.NewExecer(
-		pipeline.NopReporter(),
-		console.New(commy.Pretty),
+		reporters,
+		streamer,
 		pipeline.NopUploader(),
-		engine,
+		eng,
 		commy.Procs,
 	).Exec(ctx, spec, state)
 
+	commy.TimedOut = ctx.Err() == context.DeadlineExceeded || (grace != nil && grace.TimedOut())
+
+	if retry != nil {
+		commy.StepAttempts = retry.Attempts()
+	}
+	runs := stepRuns(spec, recorder, skipped)
+	commy.StepRuns = runs
+	for _, r := range runs {
+		log.Infof("Step %s: %s", r.Name, r.Result)
+	}
+	if out.Log != "" {
+		log.Infof("Logs written to %s", out.Log)
+	}
+	summary := newRunSummary(commy, state, recorder, runs)
+	// the run is recorded once done, with its provenance only when it was written
+	run := runRecord{
+		Timestamp: time.Now().Unix(),
+		Source:    commy.Source,
+		Stage:     commy.Stage.Name,
+		Status:    runStatus(summary.Status),
+		Log:       out.Log,
+		Summary:   out.Summary,
+		OutputDir: commy.OutputDir,
+	}
+	defer recordAndPrune(commy, &run)
+	if commy.UIRefresh {
+		defer refreshUI(commy, map[string]string{
+			labelUIRefresh:    "true",
+			labelPipelineFile: comp.Labels[labelPipelineFile],
+			labelStageName:    commy.Stage.Name,
+		})
+	}
+	if commy.Timings {
+		if err := writeTimings(commy.Console, summary); err != nil {
+			log.Errorf("Error writing step timings,%v", err)
+		}
+	}
+	if out.Summary != "" {
+		if err := writeJSON(out.Summary, summary); err != nil {
+			log.Errorf("Error writing run summary,%v", err)
+		} else {
+			log.Infof("Run summary written to %s", out.Summary)
+		}
+	}
+	if commy.JUnitFile != "" {
+		if err := writeJUnit(commy.JUnitFile, newJUnitReport(state, spec, recorder)); err != nil {
+			log.Errorf("Error writing junit report,%v", err)
+		} else {
+			log.Infof("JUnit report written to %s", commy.JUnitFile)
+		}
+	}
+
+	// on cancellation, e.g. by a signal, the completed steps
+	// can still be recorded for forensic purposes.
+	if (ctx.Err() != nil || commy.TimedOut) && commy.ProvenanceOnCancel {
+		log.Warnln("Run cancelled, generating the provenance of the completed steps")
+		pctx, pcancel := provenanceContext(commy.ProvenanceTimeout)
+		defer pcancel()
+		if err := generateStatement(pctx, commy, p, completedSteps(spec, state), subjects, out.Provenance, true); err != nil {
+			log.Errorf("Error generating the provenance of the cancelled run,%v", err)
+		} else {
+			run.Provenance = out.Provenance
+		}
+	}
+
+	if services != nil {
+		if failure := services.Failure(); failure != "" {
+			return withKind(ErrBuildFailed, fmt.Errorf("stage '%s' halted, %s", state.Stage.Name, failure))
+		}
+	}
+
 	if err != nil {
-		dump(state)
+		dump(commy.Console, state)
 		return err
 	}
 
 	switch state.Stage.Status {
 	case drone.StatusError, drone.StatusFailing, drone.StatusKilled:
-		os.Exit(1)
+		return withKind(ErrBuildFailed, fmt.Errorf("stage '%s' %s", state.Stage.Name, state.Stage.Status))
+	}
+	if commy.TimedOut {
+		return withKind(ErrBuildFailed, fmt.Errorf("stage '%s' timed out", state.Stage.Name))
 	}
 
 	if err != nil {
 		return err
 	}
 
-	generateStatement(commy, p, spec)
+	// the build context might be close to its deadline, hence
+	// bound the provenance generation with its own timeout.
+	pctx, pcancel := provenanceContext(commy.ProvenanceTimeout)
+	defer pcancel()
 
+	if commy.SubjectFrom != "" {
+		s, err := imageSubject(pctx, commy.SubjectFrom, commy.Offline)
+		if err != nil {
+			return err
+		}
+		subjects = []intoto.Subject{s}
+	}
+	if len(commy.SubjectGlobs) > 0 {
+		if commy.Clone {
+			log.Warnln("Skipping --subject-glob, the workspace is not mounted from the host when cloning")
+		} else {
+			pwd, _ := os.Getwd()
+			gs, err := globSubjects(pwd, commy.SubjectGlobs)
+			if err != nil {
+				return err
+			}
+			subjects = append(subjects, gs...)
+		}
+	}
+
+	if err := generateStatement(pctx, commy, p, spec, subjects, out.Provenance, false); err != nil {
+		return err
+	}
+	run.Provenance = out.Provenance
 	return nil
 }
 
-func dump(v interface{}) {
-	enc := json.NewEncoder(os.Stdout)
+// recordAndPrune records the run in the run history and, with --auto-prune,
+// prunes the old runs.
+func recordAndPrune(commy *execCommand, run *runRecord) {
+	if err := recordRun(*run); err != nil {
+		log.Warnf("Unable to record the run in the run history,%v", err)
+	}
+	if commy.AutoPrune {
+		age, _ := parseAge(defaultPruneOlderThan)
+		removed, err := pruneRuns(time.Now().Add(-age), defaultPruneKeepLast)
+		if err != nil {
+			log.Warnf("Unable to prune the old runs,%v", err)
+		}
+		if len(removed) > 0 {
+			log.Infof("Pruned %d file(s) of the runs older than %s", len(removed), defaultPruneOlderThan)
+		}
+	}
+}
+
+// refreshUI notifies the extension UI to reload the pipeline, the refresh
+// image is pulled with the registry credentials of the build.
+func refreshUI(commy *execCommand, labels map[string]string) {
+	if dockerCli == nil {
+		return
+	}
+	image := utils.UIRefreshImage()
+	auth, err := registryAuth(commy.Config, image)
+	if err != nil {
+		log.Warnf("Skipping the UI refresh,%v", err)
+		return
+	}
+	if err := utils.TriggerUIRefresh(nocontext, log, dockerCli, image, auth, labels, commy.Console); err != nil {
+		log.Warnf("Unable to refresh the UI,%v", err)
+	}
+}
+
+// newDockerEngine creates the docker pipeline engine
+func newDockerEngine(opts engine.Opts) (runtime.Engine, error) {
+	return engine.NewEnv(opts)
+}
+
+// provenanceContext returns the context bounding the provenance generation
+// with its own timeout, cancelled when a signal is received.
+func provenanceContext(timeout time.Duration) (context.Context, context.CancelFunc) {
+	ctx, cancel := context.WithTimeout(nocontext, timeout)
+	ctx = signal.WithContextFunc(ctx, func() {
+		println("received signal, terminating provenance generation")
+		cancel()
+	})
+	return ctx, cancel
+}
+
+// completedSteps returns a copy of the compiled pipeline with only
+// the steps that completed, i.e. passed or failed, before cancellation.
+func completedSteps(spec *engine.Spec, state *pipeline.State) *engine.Spec {
+	state.Lock()
+	defer state.Unlock()
+	completed := map[string]bool{}
+	for _, s := range state.Stage.Steps {
+		switch s.Status {
+		case drone.StatusPassing, drone.StatusFailing:
+			completed[s.Name] = true
+		}
+	}
+	partial := *spec
+	partial.Steps = nil
+	for _, step := range spec.Steps {
+		if completed[step.Name] {
+			partial.Steps = append(partial.Steps, step)
+		}
+	}
+	return &partial
+}
+
+// findStep returns the named step of the compiled pipeline, nil if not found
+func findStep(spec *engine.Spec, name string) *engine.Step {
+	for _, step := range spec.Steps {
+		if step.Name == name {
+			return step
+		}
+	}
+	return nil
+}
+
+func dump(w io.Writer, v interface{}) {
+	enc := json.NewEncoder(w)
 	enc.SetIndent("", "  ")
 	_ = enc.Encode(v)
 }
 
-func generateStatement(commy *execCommand, p *resource.Pipeline, spec *engine.Spec) {
-	//TODO
-	var subjects []intoto.Subject
-	att := intoto.ProvenanceStatement{
-		StatementHeader: intoto.StatementHeader{
-			Type:          intoto.StatementInTotoV01,
-			PredicateType: slsa.PredicateSLSAProvenance,
-			Subject:       subjects,
-		},
-		Predicate: slsa.ProvenancePredicate{
-			BuildType: p.Kind + "/" + p.Type,
-			Builder: common.ProvenanceBuilder{
-				ID: "https://harness.drone.io/Attestations/DockerRunner",
-			},
-			Metadata: &slsa.ProvenanceMetadata{
-				BuildInvocationID: fmt.Sprintf("%d", commy.Build.ID),
+func generateStatement(ctx context.Context, commy *execCommand, p *resource.Pipeline, spec *engine.Spec, subjects []intoto.Subject, fp string, cancelled bool) error {
+	//TODO detect the subjects from the pipeline steps
+	mat := materials(ctx, commy, spec)
+	if commy.MaterialsFile != "" {
+		if err := writeJSON(commy.MaterialsFile, imageMaterials(mat)); err != nil {
+			log.Errorf("Error writing the materials file,%v", err)
+		} else {
+			log.Infof("Materials written to %s", commy.MaterialsFile)
+		}
+	}
+	if commy.FailOnMissingDigest {
+		if missing := missingDigests(mat); len(missing) > 0 {
+			for _, m := range missing {
+				log.Errorf("Unable to resolve the digest of %s", m)
+			}
+			return withKind(ErrMissingDigest, fmt.Errorf("%d material(s) without digest, not writing the attestation", len(missing)))
+		}
+	}
+	if commy.PreviousProvenance != "" {
+		prev, err := readPreviousMaterials(commy.PreviousProvenance)
+		switch {
+		case err != nil && commy.OnlyChangedImages:
+			return fmt.Errorf("unable to compare materials with the previous provenance: %w", err)
+		case err != nil:
+			log.Warnf("Unable to compare materials with the previous provenance,%v", err)
+		default:
+			diffMaterials(mat, prev, commy.Stderr)
+		}
+	}
+	// the incremental provenance references the unchanged images by the previous provenance
+	if commy.OnlyChangedImages {
+		var err error
+		if mat, err = changedImageMaterials(mat, commy.PreviousProvenance); err != nil {
+			return fmt.Errorf("unable to reference the previous provenance: %w", err)
+		}
+	}
+
+	var att interface{}
+	switch {
+	case commy.ProvenanceFormat == formatCycloneDX:
+		att = cycloneDXBOM(commy, p, mat, subjects)
+	case commy.AttestationType == attestationLink:
+		att = linkMetablock(p, withMaterialURIScheme(mat, commy.MaterialURIScheme), subjects)
+	default:
+		release := newProvenanceRelease(commy)
+		var predicate interface{} = provenancePredicate{
+			ProvenancePredicate: slsa.ProvenancePredicate{
+				BuildType: p.Kind + "/" + p.Type,
+				Invocation: slsa.ProvenanceInvocation{
+					Parameters:  withReleaseParameters(invocationParameters(commy.Build, commy.Repo.Branch), release),
+					Environment: invocationEnvironment(commy.Envs, commy.EnvAllowlistPrefixes, commy.Redactor),
+				},
+				BuildConfig: stepsBuildConfig(commy, spec),
 			},
-			Invocation: slsa.ProvenanceInvocation{
-				Parameters: commy.Build.Params,
+			Builder: newProvenanceBuilder(commy.ToolVersion),
+			Metadata: &provenanceMetadata{
+				ProvenanceMetadata: slsa.ProvenanceMetadata{
+					BuildInvocationID: fmt.Sprintf("%d", commy.Build.ID),
+				},
+				Trusted:   commy.Repo.Trusted,
+				Workspace: workspaceSource(commy.Clone),
+				Cancelled: cancelled,
+				TimedOut:  commy.TimedOut,
 			},
-			BuildConfig: map[string][]*engine.Step{
-				"steps": spec.Steps,
+			Materials: withMaterialURIScheme(mat, commy.MaterialURIScheme),
+			Release:   release,
+		}
+		if commy.PredicateExtra != nil {
+			merged, err := mergePredicateExtra(predicate, commy.PredicateExtra)
+			if err != nil {
+				return fmt.Errorf("unable to add the predicate extra fields: %w", err)
+			}
+			predicate = merged
+		}
+		// the predicate alone is attested by e.g. cosign attest --predicate
+		if commy.PredicateOnly {
+			att = predicate
+			break
+		}
+		att = intoto.Statement{
+			StatementHeader: intoto.StatementHeader{
+				Type:          commy.StatementType,
+				PredicateType: slsa.PredicateSLSAProvenance,
+				Subject:       subjects,
 			},
-			Materials: materials(spec),
-		},
+			Predicate: predicate,
+		}
 	}
 
-	pf := commy.Source
-	//TODO: save/upload to storage/repo for now dump json to file
-	fp := path.Join(path.Dir(pf), path.Base(pf), "-provenance.json")
-	f, err := os.Create(fp)
-	if err != nil {
-		log.Errorf("Error generating attestation,%v", err)
-		return
+	if commy.Sign {
+		bundle, err := newKeylessSigner(commy).Sign(ctx, att, signingPayloadType(commy.ProvenanceFormat))
+		if err != nil {
+			return err
+		}
+		att = bundle
 	}
-	enc := json.NewEncoder(f)
-	if err := enc.Encode(att); err != nil {
-		log.Errorf("Error generating attestation json,%v", err)
+
+	for _, sink := range provenanceSinks(commy, fp) {
+		if err := sink.Write(ctx, att); err != nil {
+			return fmt.Errorf("unable to write the attestation: %w", err)
+		}
 	}
+	return nil
 }
 
-func buildConfig(spec *engine.Spec) map[string]string {
-	bc := make(map[string]string)
-
+// stepsBuildConfig returns the build config of the provenance i.e. the compiled
+// steps, with the secrets and netrc credentials redacted, whether each step ran
+// and, with --step-command-hashes, the fingerprint of each step.
+func stepsBuildConfig(commy *execCommand, spec *engine.Spec) map[string]interface{} {
+	bc := map[string]interface{}{
+		"steps": redactedSpec(spec, commy.Redactor).Steps,
+	}
+	if commy.StepCommandHashes {
+		bc["commandHashes"] = buildConfig(spec)
+	}
+	// whether each step ran or why it was skipped, e.g. by its when condition
+	if len(commy.StepRuns) > 0 {
+		results := make(map[string]string, len(commy.StepRuns))
+		for _, r := range commy.StepRuns {
+			results[r.Name] = r.Result
+		}
+		bc["stepResults"] = results
+	}
+	// the number of times each step was run, with --step-retries
+	if len(commy.StepAttempts) > 0 {
+		bc["stepAttempts"] = commy.StepAttempts
+	}
 	return bc
 }
 
-func materials(spec *engine.Spec) []common.ProvenanceMaterial {
-	var mat []common.ProvenanceMaterial
+// buildConfig returns the fingerprint of what each step does keyed by the step name,
+// the sha256 of its entrypoint, command and script or, for the plugin steps that have
+// no script, of their settings.
+func buildConfig(spec *engine.Spec) map[string]string {
+	bc := make(map[string]string)
 	for _, s := range spec.Steps {
-		dig, _ := crane.Digest(s.Image)
-		mat = append(mat, common.ProvenanceMaterial{
-			URI: fmt.Sprintf("pkg:%s@%s", s.Image, dig),
-			Digest: common.DigestSet{
-				"sha256": dig,
-			},
-		})
+		parts := append(append([]string{}, s.Entrypoint...), s.Command...)
+		if script, ok := s.Envs["DRONE_SCRIPT"]; ok {
+			parts = append(parts, script)
+		} else {
+			var settings []string
+			for k, v := range s.Envs {
+				if strings.HasPrefix(k, "PLUGIN_") {
+					settings = append(settings, k+"="+v)
+				}
+			}
+			sort.Strings(settings)
+			parts = append(parts, settings...)
+		}
+		sum := sha256.Sum256([]byte(strings.Join(parts, "\n")))
+		bc[s.Name] = "sha256:" + hex.EncodeToString(sum[:])
 	}
-	return mat
+	return bc
 }
@@ -0,0 +1,423 @@
+package drone
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"os"
+	"path"
+	"reflect"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/drone-runners/drone-runner-docker/engine"
+	"github.com/drone/runner-go/pipeline/runtime"
+	"github.com/urfave/cli/v2"
+)
+
+const testPipeline = `kind: pipeline
+type: docker
+name: default
+
+steps:
+- name: build
+  image: busybox
+  commands:
+  - echo build
+- name: test
+  image: busybox
+  commands:
+  - echo test
+- name: publish
+  image: busybox
+  commands:
+  - echo publish
+`
+
+// stubEngine is a pipeline engine recording the steps it is asked to run,
+// the steps log their name and exit with the canned exit codes, zero by default.
+type stubEngine struct {
+	exitCodes map[string]int
+
+	mu  sync.Mutex
+	ran []string
+}
+
+var _ runtime.Engine = (*stubEngine)(nil)
+
+func (e *stubEngine) Setup(context.Context, runtime.Spec) error {
+	return nil
+}
+
+func (e *stubEngine) Destroy(context.Context, runtime.Spec) error {
+	return nil
+}
+
+func (e *stubEngine) Run(_ context.Context, _ runtime.Spec, step runtime.Step, output io.Writer) (*runtime.State, error) {
+	name := step.GetName()
+	fmt.Fprintf(output, "running %s\n", name)
+	e.mu.Lock()
+	defer e.mu.Unlock()
+	if name != "clone" {
+		e.ran = append(e.ran, name)
+	}
+	return &runtime.State{Exited: true, ExitCode: e.exitCodes[name]}, nil
+}
+
+// Ran returns the steps run, in order
+func (e *stubEngine) Ran() []string {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+	return append([]string(nil), e.ran...)
+}
+
+// runStubExec runs the exec command with the stub engine on the pipeline
+// written to a temporary directory, it returns the provenance file.
+func runStubExec(t *testing.T, eng *stubEngine, pipeline string, args ...string) (string, error) {
+	t.Helper()
+	provenance := path.Join(t.TempDir(), "provenance.json")
+	return provenance, runStubExecOutput(t, eng, pipeline, io.Discard, io.Discard, append([]string{"--provenance-file", provenance}, args...)...)
+}
+
+// runStubExecOutput runs the exec command with the stub engine on the pipeline
+// written to a temporary directory, writing to the stdout and stderr writers.
+func runStubExecOutput(t *testing.T, eng *stubEngine, pipeline string, stdout, stderr io.Writer, args ...string) error {
+	t.Helper()
+	log.SetOutput(io.Discard)
+	dir := t.TempDir()
+	droneCIHome = path.Join(dir, ".drone-ci")
+	droneCILogsDir = path.Join(droneCIHome, "logs")
+	source := path.Join(dir, ".drone.yml")
+	if err := os.WriteFile(source, []byte(pipeline), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	app := &cli.App{
+		Writer:    stdout,
+		ErrWriter: stderr,
+		Commands: []*cli.Command{{
+			Name:  Command.Name,
+			Flags: Command.Flags,
+			Action: func(cliContext *cli.Context) error {
+				return execWith(cliContext, execOptions{
+					newEngine: func(engine.Opts) (runtime.Engine, error) {
+						return eng, nil
+					},
+				})
+			},
+		}},
+	}
+	argv := append([]string{"drone", Command.Name, "--offline", "--no-compile-cache"}, args...)
+	return app.Run(append(argv, source))
+}
+
+// syncBuffer is a buffer safe for the concurrent writes of the steps and the logger
+type syncBuffer struct {
+	mu  sync.Mutex
+	buf bytes.Buffer
+}
+
+func (b *syncBuffer) Write(p []byte) (int, error) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	return b.buf.Write(p)
+}
+
+func (b *syncBuffer) String() string {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	return b.buf.String()
+}
+
+func TestExecIncludeExclude(t *testing.T) {
+	tests := []struct {
+		name string
+		args []string
+		want []string
+	}{
+		{"all", nil, []string{"build", "test", "publish"}},
+		{"include", []string{"--include", "build", "--include", "publish"}, []string{"build", "publish"}},
+		{"exclude", []string{"--exclude", "test"}, []string{"build", "publish"}},
+		{"include and exclude", []string{"--include", "build", "--include", "test", "--exclude", "test"}, []string{"build"}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			eng := &stubEngine{}
+			if _, err := runStubExec(t, eng, testPipeline, tt.args...); err != nil {
+				t.Fatal(err)
+			}
+			if got := eng.Ran(); !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("ran %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestExecResumeAt(t *testing.T) {
+	eng := &stubEngine{}
+	if _, err := runStubExec(t, eng, testPipeline, "--resume-at", "test"); err != nil {
+		t.Fatal(err)
+	}
+	if got, want := eng.Ran(), []string{"test", "publish"}; !reflect.DeepEqual(got, want) {
+		t.Errorf("ran %v, want %v", got, want)
+	}
+}
+
+func TestExecFailedStep(t *testing.T) {
+	eng := &stubEngine{exitCodes: map[string]int{"test": 1}}
+	provenance, err := runStubExec(t, eng, testPipeline)
+	if err == nil {
+		t.Fatal("expecting the failed step to fail the run")
+	}
+	if got, want := eng.Ran(), []string{"build", "test"}; !reflect.DeepEqual(got, want) {
+		t.Errorf("ran %v, want %v", got, want)
+	}
+	if _, err := os.Stat(provenance); !os.IsNotExist(err) {
+		t.Errorf("expecting no provenance for the failed run, %v", err)
+	}
+}
+
+func TestExecProvenance(t *testing.T) {
+	eng := &stubEngine{}
+	provenance, err := runStubExec(t, eng, testPipeline, "--exclude", "publish")
+	if err != nil {
+		t.Fatal(err)
+	}
+	b, err := os.ReadFile(provenance)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var statement struct {
+		PredicateType string `json:"predicateType"`
+		Predicate     struct {
+			BuildConfig struct {
+				Steps []struct {
+					Name  string `json:"name"`
+					Image string `json:"image"`
+				} `json:"steps"`
+				StepResults map[string]string `json:"stepResults"`
+			} `json:"buildConfig"`
+			Materials []struct {
+				URI string `json:"uri"`
+			} `json:"materials"`
+		} `json:"predicate"`
+	}
+	if err := json.Unmarshal(b, &statement); err != nil {
+		t.Fatalf("invalid provenance %s, %v", b, err)
+	}
+	if statement.PredicateType != "https://slsa.dev/provenance/v0.2" {
+		t.Errorf("predicate type %s", statement.PredicateType)
+	}
+	var steps []string
+	for _, s := range statement.Predicate.BuildConfig.Steps {
+		steps = append(steps, s.Name)
+	}
+	for _, want := range []string{"build", "test", "publish"} {
+		found := false
+		for _, s := range steps {
+			found = found || s == want
+		}
+		if !found {
+			t.Errorf("step %s missing from the build config steps %v", want, steps)
+		}
+	}
+	if got, want := statement.Predicate.BuildConfig.StepResults["publish"], "skipped ("+skipExcluded+")"; got != want {
+		t.Errorf("publish step result %q, want %q", got, want)
+	}
+	found := false
+	for _, m := range statement.Predicate.Materials {
+		found = found || strings.Contains(m.URI, "busybox")
+	}
+	if !found {
+		t.Errorf("busybox image missing from the materials %v", statement.Predicate.Materials)
+	}
+}
+
+const pullRequestPipeline = `kind: pipeline
+type: docker
+name: default
+
+steps:
+- name: build
+  image: busybox
+  commands:
+  - echo build
+- name: preview
+  image: busybox
+  commands:
+  - echo preview
+  when:
+    event:
+    - pull_request
+    branch:
+    - main
+`
+
+func TestExecPullRequestEvent(t *testing.T) {
+	tests := []struct {
+		name string
+		args []string
+		want []string
+	}{
+		{"pull request", []string{"--build-event", "pull_request", "--branch", "main"}, []string{"build", "preview"}},
+		{"source branch", []string{"--source-branch", "feature", "--target-branch", "main"}, []string{"build", "preview"}},
+		{"other target branch", []string{"--source-branch", "feature", "--target-branch", "release"}, []string{"build"}},
+		{"push", []string{"--build-event", "push", "--branch", "main"}, []string{"build"}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			eng := &stubEngine{}
+			if _, err := runStubExec(t, eng, pullRequestPipeline, tt.args...); err != nil {
+				t.Fatal(err)
+			}
+			if got := eng.Ran(); !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("ran %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestExecRedactsCredentials(t *testing.T) {
+	dir := t.TempDir()
+	secretFile := path.Join(dir, "secrets")
+	if err := os.WriteFile(secretFile, []byte("api_key=secret-value\n"), 0o600); err != nil {
+		t.Fatal(err)
+	}
+	registryFile := path.Join(dir, "config.json")
+	// the auth of the registry is base64 of user:registry-password
+	if err := os.WriteFile(registryFile, []byte(`{"auths":{"registry.example.com":{"auth":"dXNlcjpyZWdpc3RyeS1wYXNzd29yZA=="}}}`), 0o600); err != nil {
+		t.Fatal(err)
+	}
+	// the credentials set in the environment, and recorded variables
+	// holding the values of the credentials
+	envs := map[string]string{
+		"SIGSTORE_ID_TOKEN":      "identity-token-value",
+		"DRONE_IDENTITY_COPY":    "identity-token-value",
+		"DRONE_SECRET_COPY":      "secret-value",
+		"DRONE_REGISTRY_COPY":    "registry-password",
+		"DRONE_PROVENANCE_TOKEN": "provenance-token-value",
+		"DRONE_NETRC_PASSWORD":   "netrc-password-value",
+	}
+	for k, v := range envs {
+		t.Setenv(k, v)
+	}
+	specFile := path.Join(dir, "spec.json")
+	provenance, err := runStubExec(t, &stubEngine{}, testPipeline,
+		"--secret-file", secretFile, "--registry", registryFile, "--dump-spec", specFile)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, f := range []string{provenance, specFile} {
+		b, err := os.ReadFile(f)
+		if err != nil {
+			t.Fatal(err)
+		}
+		for k, v := range envs {
+			if strings.Contains(string(b), v) {
+				t.Errorf("%s leaks the value of %s", path.Base(f), k)
+			}
+		}
+	}
+	b, err := os.ReadFile(provenance)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var statement struct {
+		Predicate struct {
+			Invocation struct {
+				Environment map[string]string `json:"environment"`
+			} `json:"invocation"`
+		} `json:"predicate"`
+	}
+	if err := json.Unmarshal(b, &statement); err != nil {
+		t.Fatalf("invalid provenance %s, %v", b, err)
+	}
+	for k := range envs {
+		if v, ok := statement.Predicate.Invocation.Environment[k]; ok && v != redactedValue {
+			t.Errorf("environment %s=%q, want %q", k, v, redactedValue)
+		}
+	}
+}
+
+func TestExecDefaultLogFile(t *testing.T) {
+	if _, err := runStubExec(t, &stubEngine{}, testPipeline, "--include", "build"); err != nil {
+		t.Fatal(err)
+	}
+	history, err := readRunHistory()
+	if err != nil || len(history) != 1 {
+		t.Fatalf("expecting the run in the history, %v %v", history, err)
+	}
+	logFile := history[0].Log
+	if path.Dir(logFile) != droneCILogsDir {
+		t.Errorf("log file %s, want under %s", logFile, droneCILogsDir)
+	}
+	b, err := os.ReadFile(logFile)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !strings.Contains(string(b), "running build") {
+		t.Errorf("log file %s misses the step logs: %s", logFile, b)
+	}
+}
+
+func TestExecLogFormat(t *testing.T) {
+	tests := []struct {
+		format string
+		prefix string
+	}{
+		{logFormatJSON, "{"},
+		{logFormatPlain, time.Now().UTC().Format("2006")},
+		{logFormatConsole, ""},
+	}
+	for _, tt := range tests {
+		t.Run(tt.format, func(t *testing.T) {
+			if _, err := runStubExec(t, &stubEngine{}, testPipeline, "--include", "build", "--log-format", tt.format); err != nil {
+				t.Fatal(err)
+			}
+			history, err := readRunHistory()
+			if err != nil || len(history) != 1 {
+				t.Fatalf("expecting the run in the history, %v %v", history, err)
+			}
+			logFile := history[0].Log
+			if tt.format == logFormatConsole {
+				if logFile != "" {
+					t.Errorf("expecting no log file, got %s", logFile)
+				}
+				return
+			}
+			b, err := os.ReadFile(logFile)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if !strings.HasPrefix(string(b), tt.prefix) {
+				t.Errorf("%s log file starts with %q", tt.format, b)
+			}
+		})
+	}
+}
+
+func TestExecProvenanceStdout(t *testing.T) {
+	stdout, stderr := &syncBuffer{}, &syncBuffer{}
+	if err := runStubExecOutput(t, &stubEngine{}, testPipeline, stdout, stderr, "--include", "build", "--provenance-stdout"); err != nil {
+		t.Fatal(err)
+	}
+	dec := json.NewDecoder(strings.NewReader(stdout.String()))
+	var statement struct {
+		PredicateType string `json:"predicateType"`
+	}
+	if err := dec.Decode(&statement); err != nil || statement.PredicateType == "" {
+		t.Fatalf("expecting the provenance on stdout, got %q, %v", stdout.String(), err)
+	}
+	if dec.More() {
+		t.Errorf("expecting only the provenance on stdout, got %q", stdout.String())
+	}
+	if !strings.Contains(stderr.String(), "[build:1] running build") {
+		t.Errorf("expecting the step logs on stderr, got %q", stderr.String())
+	}
+	if os.Stdout == os.Stderr {
+		t.Error("expecting os.Stdout to be left as is")
+	}
+}
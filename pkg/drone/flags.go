@@ -5,12 +5,29 @@
 package drone
 
 import (
+	"bufio"
+	"crypto"
+	"encoding/json"
+	"fmt"
+	"io"
+	"math"
+	"net"
+	"net/http"
+	"os"
+	"path"
+	"path/filepath"
+	"regexp"
 	"strings"
+	"text/template"
+	"time"
 
+	"github.com/docker/go-units"
 	"github.com/drone-runners/drone-runner-docker/engine/compiler"
 	"github.com/drone/drone-go/drone"
 	"github.com/joho/godotenv"
+	"github.com/kameshsampath/drone-provenance/pkg/utils"
 	"github.com/urfave/cli/v2"
+	"gopkg.in/yaml.v2"
 )
 
 // Flags maps
@@ -25,95 +42,600 @@ type Flags struct {
 type execCommand struct {
 	*Flags
 
-	Source     string
-	Include    []string
-	Exclude    []string
-	Privileged []string
-	Networks   []string
-	Volumes    map[string]string
-	Environ    map[string]string
-	Labels     map[string]string
-	Secrets    map[string]string
-	Resources  compiler.Resources
-	Tmate      compiler.Tmate
-	Clone      bool
-	Config     string
-	Pretty     bool
-	Procs      int64
-	Debug      bool
-	Trace      bool
-	Dump       bool
-	PublicKey  string
-	PrivateKey string
-}
-
-func toExecCommand(input *cli.Context) (returnVal *execCommand) {
+	Source               string
+	Include              []string
+	Exclude              []string
+	Privileged           []string
+	Networks             []string
+	Volumes              map[string]string
+	Environ              map[string]string
+	Labels               map[string]string
+	Secrets              map[string]string
+	Resources            compiler.Resources
+	Tmate                compiler.Tmate
+	Clone                bool
+	Config               string
+	Pretty               bool
+	Procs                int64
+	Debug                bool
+	Trace                bool
+	Dump                 bool
+	PublicKey            string
+	PrivateKey           string
+	Sign                 bool
+	IdentityToken        string
+	FulcioURL            string
+	RekorURL             string
+	Subjects             []string
+	SubjectsFile         string
+	ExcludeCloneMaterial bool
+	ProvenanceTimeout    time.Duration
+	AttestationType      string
+	StatementType        string
+	ImageVars            map[string]map[string]string
+	OutputDir            string
+	ReadonlySource       bool
+	NoClone              bool
+	Progress             bool
+	FailFast             bool
+	KeepGoing            bool
+	ToolVersion          string
+	MaxLogSize           int64
+	LintStrict           bool
+	SkipLint             bool
+	SubjectFrom          string
+	SourceDigest         string
+	ProvenanceFile       string
+	ProvenanceStdout     bool
+	PreviousProvenance   string
+	TraceFile            string
+	KeepWorkspace        bool
+	KeepContainers       bool
+	ProvenanceFormat     string
+	SubjectGlobs         []string
+	JUnitFile            string
+	Envs                 map[string]string
+	EnvAllowlistPrefixes []string
+	Offline              bool
+	PredicateExtra       map[string]json.RawMessage
+	StepEnvs             map[string]map[string]string
+	ProvenanceOnCancel   bool
+	ProvenanceGzip       bool
+	ProvenanceChecksum   bool
+	PipelineIndex        int
+	ImageOverrides       map[string]string
+	ImageOriginals       map[string]string
+	FailOnMissingDigest  bool
+	Timings              bool
+	RegistryMirror       string
+	StepCommandHashes    bool
+	NoColor              bool
+	DumpSpec             string
+	ExtraHosts           []string
+	ProvenanceAttach     string
+	ProvenanceURL        string
+	ProvenanceHeaders    map[string]string
+	ProvenanceDSSE       bool
+	ProvenanceRetries    int
+	Lockfile             string
+	LockfileType         string
+	Since                string
+	HidePull             bool
+	LogFormat            string
+	StepRuns             []stepRun
+	PipelineFileLabel    string
+	Plan                 bool
+	Events               string
+	HTTPProxy            string
+	HTTPSProxy           string
+	NoProxy              string
+	TimeoutGrace         time.Duration
+	TimedOut             bool
+	ServiceMaterials     bool
+	ProvenanceIndent     bool
+	StepRetries          map[string]int
+	StepAttempts         map[string]int
+	MaterialsFile        string
+	VerifyImages         bool
+	VerifyKey            crypto.PublicKey
+	VerifyExempt         []string
+	ImageSignatures      map[string]string
+	ProvenanceName       *template.Template
+	Includes             []includedFile
+	FailOnLintRules      []string
+	Selector             labelSelector
+	NoCompileCache       bool
+	HaltOnServiceFailure bool
+	PredicateOnly        bool
+	AllowEmptyRun        bool
+	MaterialURIScheme    string
+	Release              bool
+	ReleaseTag           string
+	AutoPrune            bool
+	OnlyChangedImages    bool
+	StrictLogging        bool
+	UIRefresh            bool
+	Redactor             *redactor
+	Stdout               io.Writer
+	Stderr               io.Writer
+	Console              io.Writer
+}
+
+func toExecCommand(input *cli.Context) (returnVal *execCommand, err error) {
 	pipelineFile := input.Args().First()
 	if pipelineFile == "" {
 		pipelineFile = ".drone.yml"
 	}
+	envs, err := readParams(input.String("env-file"))
+	if err != nil {
+		return nil, fmt.Errorf("invalid --env-file %w", err)
+	}
+	secrets, err := readParams(input.String("secret-file"))
+	if err != nil {
+		return nil, fmt.Errorf("invalid --secret-file %w", err)
+	}
+	redactor, err := newRedactor(input, secrets, input.String("registry"))
+	if err != nil {
+		return nil, err
+	}
+	stepEnvs, err := parseStepEnvs(input.StringSlice("step-env"))
+	if err != nil {
+		return nil, err
+	}
+	stepRetries, err := parseStepRetries(input.StringSlice("step-retries"))
+	if err != nil {
+		return nil, err
+	}
+	selector, err := parseLabelSelector(input.String("select"))
+	if err != nil {
+		return nil, err
+	}
+	extraHosts, err := parseExtraHosts(input.StringSlice("add-host"))
+	if err != nil {
+		return nil, err
+	}
+	imageOverrides, err := parseImageOverrides(input.StringSlice("image-override"))
+	if err != nil {
+		return nil, err
+	}
+	provenanceHeaders, err := parseHeaders(input.StringSlice("provenance-header"))
+	if err != nil {
+		return nil, err
+	}
+	if token := input.String("provenance-token"); token != "" {
+		provenanceHeaders["Authorization"] = "Bearer " + token
+	}
+	noColor := input.Bool("no-color") || utils.NoColor()
+	// stdout is kept for the provenance or the events written to it,
+	// everything else, including the step logs, is written to stderr
+	console := input.App.Writer
+	if input.Bool("provenance-stdout") || input.String("events") == stdoutPath {
+		console = input.App.ErrWriter
+	}
+	consoleFile, isFile := console.(*os.File)
+	registryMirror, err := parseRegistryMirror(input.String("registry-mirror"))
+	if err != nil {
+		return nil, err
+	}
+	include, err := withStepNamesFile(input.StringSlice("include"), input.String("include-file"))
+	if err != nil {
+		return nil, err
+	}
+	exclude, err := withStepNamesFile(input.StringSlice("exclude"), input.String("exclude-file"))
+	if err != nil {
+		return nil, err
+	}
+	event, ref, err := buildEventRef(input.String("build-event"), input.String("ref"), input.String("tag"))
+	if err != nil {
+		return nil, err
+	}
+	source, target, err := pullRequestBranches(event, input.String("source-branch"), input.String("target-branch"), input.String("branch"))
+	if err != nil {
+		return nil, err
+	}
+	if source != "" && event == "" {
+		event = drone.EventPullRequest
+	}
+	netrc, err := netrcCredentials(input.String("netrc-file"), input.String("netrc-machine"), input.String("netrc-username"), input.String("netrc-password"))
+	if err != nil {
+		return nil, err
+	}
 	returnVal = &execCommand{
 		Flags: &Flags{
 			Build: &drone.Build{
-				Event:  input.String("event"),
-				Ref:    input.String("ref"),
+				Number: input.Int64("build-number"),
+				Event:  event,
+				Ref:    ref,
 				Deploy: input.String("deploy-to"),
-				Target: input.String("branch"),
+				Source: source,
+				Target: target,
 			},
 			Repo: &drone.Repo{
 				Trusted: input.Bool("trusted"),
-				Timeout: int64(input.Duration("timeout").Seconds()),
+				Timeout: timeoutMinutes(input.Duration("timeout")),
 				Branch:  input.String("branch"),
 				Name:    input.String("name"),
+				HTTPURL: input.String("remote-url"),
 			},
 			Stage: &drone.Stage{
 				Name: input.String("pipeline"),
 			},
-			Netrc: &drone.Netrc{
-				Machine:  input.String("netrc-machine"),
-				Login:    input.String("netrc-username"),
-				Password: input.String("netrc-password"),
-			},
+			Netrc: netrc,
 			System: &drone.System{
 				Host: input.String("instance"),
 			},
 		},
-		Source:          pipelineFile,
-		Include:         input.StringSlice("include"),
-		Exclude:         input.StringSlice("exclude"),
-		Clone:           input.Bool("clone"),
-		Networks:        input.StringSlice("network"),
-		Environ:         readParams(input.String("env-file")),
-		Volumes:         withVolumeSlice(input.StringSlice("volume")),
-		Secrets:         readParams(input.String("secret-file")),
-		Config:          input.String("registry"),
-		Privileged:      input.StringSlice("privileged"),
-		MinioAccessKey:  input.String("minio-access-key"),
-		MinioSecretKey:  input.String("minio-secret-key"),
-		MinioEndpoint:   input.String("minio-endpoint"),
-		MinioBucketName: input.String("minio-bucket"),
-	}
-
-	return returnVal
-}
-
-// WithVolumeSlice is a transform function that adds a set of global volumes to the container that are defined in --volume=host:container format.
-func withVolumeSlice(volumes []string) (to map[string]string) {
-	to = map[string]string{}
+		Source:               pipelineFile,
+		Pretty:               !noColor && isFile && utils.IsTerminal(consoleFile),
+		Include:              include,
+		Exclude:              exclude,
+		Clone:                input.Bool("clone"),
+		Environ:              envs,
+		Labels:               withLabelSlice(input.StringSlice("label")),
+		Secrets:              secrets,
+		Redactor:             redactor,
+		Stdout:               input.App.Writer,
+		Stderr:               input.App.ErrWriter,
+		Console:              console,
+		Config:               input.String("registry"),
+		Privileged:           input.StringSlice("privileged"),
+		Subjects:             input.StringSlice("subject"),
+		SubjectsFile:         input.String("subjects-file"),
+		ExcludeCloneMaterial: input.Bool("exclude-clone-material"),
+		ProvenanceTimeout:    input.Duration("provenance-timeout"),
+		AttestationType:      input.String("attestation-type"),
+		StatementType:        input.String("statement-type"),
+		OutputDir:            input.String("output-dir"),
+		ReadonlySource:       input.Bool("readonly-source"),
+		NoClone:              input.Bool("no-clone"),
+		Progress:             input.Bool("progress"),
+		FailFast:             input.Bool("fail-fast"),
+		KeepGoing:            input.Bool("keep-going"),
+		ToolVersion:          input.App.Version,
+		MaxLogSize:           maxLogSize(input.String("max-log-size")),
+		LintStrict:           input.Bool("lint-strict"),
+		SkipLint:             input.Bool("skip-lint"),
+		FailOnLintRules:      input.StringSlice("fail-on-lint-rule"),
+		Selector:             selector,
+		NoCompileCache:       input.Bool("no-compile-cache"),
+		HaltOnServiceFailure: input.Bool("halt-on-service-failure"),
+		PredicateOnly:        input.Bool("predicate-only"),
+		AllowEmptyRun:        input.Bool("allow-empty-run"),
+		MaterialURIScheme:    input.String("material-uri-scheme"),
+		Release:              input.Bool("release"),
+		ReleaseTag:           input.String("release-tag"),
+		AutoPrune:            input.Bool("auto-prune"),
+		OnlyChangedImages:    input.Bool("only-changed-images"),
+		StrictLogging:        input.Bool("strict-logging"),
+		UIRefresh:            input.Bool("ui-refresh"),
+		SubjectFrom:          input.String("provenance-subject-from"),
+		ProvenanceFile:       input.String("provenance-file"),
+		ProvenanceStdout:     input.Bool("provenance-stdout"),
+		PreviousProvenance:   input.String("previous-provenance"),
+		TraceFile:            input.String("trace-file"),
+		KeepWorkspace:        input.Bool("keep-workspace"),
+		KeepContainers:       input.Bool("keep-containers"),
+		ProvenanceFormat:     input.String("provenance-format"),
+		SubjectGlobs:         input.StringSlice("subject-glob"),
+		JUnitFile:            input.String("junit-file"),
+		EnvAllowlistPrefixes: input.StringSlice("env-allowlist-prefix"),
+		Offline:              input.Bool("offline"),
+		StepEnvs:             stepEnvs,
+		ProvenanceOnCancel:   input.Bool("provenance-on-cancel"),
+		ProvenanceGzip:       input.Bool("provenance-gzip"),
+		ProvenanceChecksum:   input.Bool("provenance-checksum"),
+		PipelineIndex:        input.Int("pipeline-index"),
+		ImageOverrides:       imageOverrides,
+		FailOnMissingDigest:  input.Bool("fail-on-missing-digest"),
+		Timings:              input.Bool("timings"),
+		RegistryMirror:       registryMirror,
+		StepCommandHashes:    input.Bool("step-command-hashes"),
+		NoColor:              noColor,
+		DumpSpec:             input.String("dump-spec"),
+		ExtraHosts:           extraHosts,
+		ProvenanceAttach:     input.String("provenance-attach"),
+		ProvenanceURL:        input.String("provenance-url"),
+		ProvenanceHeaders:    provenanceHeaders,
+		ProvenanceDSSE:       input.Bool("provenance-dsse"),
+		ProvenanceRetries:    input.Int("provenance-retries"),
+		Sign:                 input.Bool("sign"),
+		IdentityToken:        input.String("identity-token"),
+		FulcioURL:            input.String("fulcio-url"),
+		RekorURL:             input.String("rekor-url"),
+		Lockfile:             input.String("lockfile"),
+		LockfileType:         input.String("lockfile-type"),
+		Since:                input.String("since"),
+		HidePull:             input.Bool("hide-pull"),
+		LogFormat:            input.String("log-format"),
+		PipelineFileLabel:    input.String("pipeline-file"),
+		Plan:                 input.Bool("plan"),
+		Events:               input.String("events"),
+		HTTPProxy:            input.String("http-proxy"),
+		HTTPSProxy:           input.String("https-proxy"),
+		NoProxy:              input.String("no-proxy"),
+		TimeoutGrace:         input.Duration("timeout-grace"),
+		ServiceMaterials:     input.Bool("include-service-materials"),
+		ProvenanceIndent:     input.Bool("provenance-indent"),
+		StepRetries:          stepRetries,
+		MaterialsFile:        input.String("materials-file"),
+		VerifyImages:         input.Bool("verify-images"),
+		VerifyExempt:         input.StringSlice("verify-exempt"),
+	}
+
+	// the volumes and networks are expanded with the variables of the pipeline source
+	vars := pipelineEnvs(input, returnVal.Flags)
+	volumes, err := expandFlagValues(input.StringSlice("volume"), vars)
+	if err != nil {
+		return nil, fmt.Errorf("invalid --volume %w", err)
+	}
+	if returnVal.Volumes, err = parseVolumes(volumes, input.Bool("create-volume-dirs")); err != nil {
+		return nil, err
+	}
+	if returnVal.Networks, err = expandFlagValues(input.StringSlice("network"), vars); err != nil {
+		return nil, fmt.Errorf("invalid --network %w", err)
+	}
+
+	return returnVal, nil
+}
+
+// namedVolume matches the docker named volumes, as opposed to host paths
+var namedVolume = regexp.MustCompile(`^[a-zA-Z0-9][a-zA-Z0-9_.-]*$`)
+
+// parseVolumes validates the global volumes that are defined in --volume=host:container[:mode] format,
+// where mode is either ro or rw. The named volumes are passed as is, the relative host paths are resolved
+// against the working directory and the host paths must exist unless createDirs is set, in which case the
+// missing host directories are created.
+func parseVolumes(volumes []string, createDirs bool) (map[string]string, error) {
+	to := map[string]string{}
 	for _, s := range volumes {
 		parts := strings.Split(s, ":")
-		if len(parts) != 2 {
-			continue
+		if len(parts) < 2 || len(parts) > 3 || parts[0] == "" || parts[1] == "" {
+			return nil, fmt.Errorf("invalid volume '%s', expecting host:container[:mode]", s)
+		}
+		host := parts[0]
+		if !namedVolume.MatchString(host) {
+			var err error
+			if host, err = filepath.Abs(host); err != nil {
+				return nil, fmt.Errorf("invalid volume '%s' : %w", s, err)
+			}
+			if _, err := os.Stat(host); err != nil {
+				if !createDirs || !os.IsNotExist(err) {
+					return nil, fmt.Errorf("invalid volume '%s', host path %s does not exist", s, host)
+				}
+				if err := os.MkdirAll(host, 0o755); err != nil {
+					return nil, fmt.Errorf("invalid volume '%s', unable to create host path %s : %w", s, host, err)
+				}
+				log.Infof("Created the host directory %s of volume %s", host, parts[1])
+			}
+		}
+		if !path.IsAbs(parts[1]) {
+			return nil, fmt.Errorf("invalid volume '%s', container path %s must be absolute", s, parts[1])
 		}
-		key := parts[0]
 		val := parts[1]
-		to[key] = val
+		if len(parts) == 3 {
+			switch parts[2] {
+			case "ro":
+				val += ":ro"
+			case "rw":
+			default:
+				return nil, fmt.Errorf("invalid volume '%s', mode must be either ro or rw", s)
+			}
+		}
+		to[host] = val
+	}
+	return to, nil
+}
+
+// pullRequestBranches returns the source and target branches of the build, the
+// target branch of a pull request defaults to the branch of the build. The
+// source and target branches are only of the pull_request builds.
+func pullRequestBranches(event, source, target, branch string) (string, string, error) {
+	if (source != "" || target != "") && event != "" && event != drone.EventPullRequest {
+		return "", "", fmt.Errorf("--source-branch and --target-branch require --build-event %s, not %s", drone.EventPullRequest, event)
+	}
+	if target == "" {
+		target = branch
+	}
+	return source, target, nil
+}
+
+// buildEvents are the events of the builds
+var buildEvents = []string{"", drone.EventPush, drone.EventPullRequest, drone.EventTag, drone.EventPromote, drone.EventRollback, "cron", "custom"}
+
+// buildEventRef returns the event and the git ref of the build, the tag
+// sets the ref to the tag ref and the event, when not set, to tag.
+func buildEventRef(event, ref, tag string) (string, string, error) {
+	if tag != "" {
+		ref = "refs/tags/" + tag
+		if event == "" {
+			event = drone.EventTag
+		}
+	}
+	for _, e := range buildEvents {
+		if event == e {
+			return event, ref, nil
+		}
+	}
+	return "", "", fmt.Errorf("invalid --build-event '%s', expecting one of %v", event, buildEvents[1:])
+}
+
+// parseExtraHosts validates the host entries that are defined in --add-host=name:ip format,
+// the ip being either an IPv4 or an IPv6 address.
+func parseExtraHosts(hosts []string) ([]string, error) {
+	var to []string
+	for _, s := range hosts {
+		parts := strings.SplitN(s, ":", 2)
+		if len(parts) != 2 || parts[0] == "" || net.ParseIP(parts[1]) == nil {
+			return nil, fmt.Errorf("invalid host '%s', expecting name:ip", s)
+		}
+		to = append(to, s)
+	}
+	return to, nil
+}
+
+// parseHeaders parses the HTTP headers that are defined in --provenance-header='Name: value' format.
+func parseHeaders(headers []string) (map[string]string, error) {
+	to := map[string]string{}
+	for _, s := range headers {
+		parts := strings.SplitN(s, ":", 2)
+		if len(parts) != 2 || strings.TrimSpace(parts[0]) == "" {
+			return nil, fmt.Errorf("invalid header '%s', expecting 'Name: value'", s)
+		}
+		to[http.CanonicalHeaderKey(strings.TrimSpace(parts[0]))] = strings.TrimSpace(parts[1])
+	}
+	return to, nil
+}
+
+// timeoutMinutes returns the timeout in minutes, the unit of the drone repository
+// timeout, rounded up so that a timeout is never shortened.
+func timeoutMinutes(timeout time.Duration) int64 {
+	return int64(math.Ceil(timeout.Minutes()))
+}
+
+// parseStepEnvs parses the step environment overrides that are defined in --step-env=step:KEY=VALUE format.
+func parseStepEnvs(stepEnvs []string) (map[string]map[string]string, error) {
+	to := map[string]map[string]string{}
+	for _, s := range stepEnvs {
+		i := strings.Index(s, ":")
+		if i <= 0 {
+			return nil, fmt.Errorf("invalid step env '%s', expecting step:KEY=VALUE", s)
+		}
+		parts := strings.SplitN(s[i+1:], "=", 2)
+		if len(parts) != 2 || parts[0] == "" {
+			return nil, fmt.Errorf("invalid step env '%s', expecting step:KEY=VALUE", s)
+		}
+		step := s[:i]
+		if to[step] == nil {
+			to[step] = map[string]string{}
+		}
+		to[step][parts[0]] = parts[1]
+	}
+	return to, nil
+}
+
+// withLabelSlice is a transform function that adds a set of labels to the containers that are defined in --label=key=value format.
+func withLabelSlice(labels []string) (to map[string]string) {
+	to = map[string]string{}
+	for _, s := range labels {
+		parts := strings.SplitN(s, "=", 2)
+		if len(parts) != 2 || parts[0] == "" {
+			continue
+		}
+		to[parts[0]] = parts[1]
 	}
 	return to
 }
 
-// helper function reads secrets from a key-value file.
-func readParams(path string) map[string]string {
-	data, _ := godotenv.Read(path)
-	return data
+// envKey matches the valid names of environment variables
+var envKey = regexp.MustCompile(`^[A-Za-z_][A-Za-z0-9_.-]*$`)
+
+// helper function reads params e.g. secrets from a file, either of KEY=VALUE lines
+// or a YAML map detected by the .yml/.yaml extension or the content of the file.
+// The malformed entries are reported with their line number.
+func readParams(path string) (map[string]string, error) {
+	if path == "" {
+		return nil, nil
+	}
+	b, err := os.ReadFile(path)
+	if err != nil {
+		return nil, fmt.Errorf("unable to read %s: %w", path, err)
+	}
+	if isYAMLParams(path, b) {
+		return readYAMLParams(path, b)
+	}
+	for i, line := range strings.Split(string(b), "\n") {
+		line = strings.TrimSpace(line)
+		if line == "" || strings.HasPrefix(line, "#") {
+			continue
+		}
+		parts := strings.SplitN(strings.TrimPrefix(line, "export "), "=", 2)
+		if len(parts) != 2 {
+			return nil, fmt.Errorf("%s:%d: invalid entry, expecting KEY=VALUE", path, i+1)
+		}
+		if key := strings.TrimSpace(parts[0]); !envKey.MatchString(key) {
+			return nil, fmt.Errorf("%s:%d: invalid key '%s'", path, i+1, key)
+		}
+	}
+	data, err := godotenv.Unmarshal(string(b))
+	if err != nil {
+		return nil, fmt.Errorf("%s: %w", path, err)
+	}
+	return data, nil
+}
+
+// isYAMLParams returns true if the params file is a YAML file by its extension
+// or when its first entry is of the form key: value
+func isYAMLParams(path string, b []byte) bool {
+	switch filepath.Ext(path) {
+	case ".yml", ".yaml":
+		return true
+	}
+	for _, line := range strings.Split(string(b), "\n") {
+		line = strings.TrimSpace(line)
+		if line == "" || strings.HasPrefix(line, "#") || line == "---" {
+			continue
+		}
+		i := strings.Index(line, ":")
+		return i > 0 && !strings.Contains(line[:i], "=")
+	}
+	return false
+}
+
+// readYAMLParams reads the params of a YAML map with scalar values, the keys
+// and values are kept as written e.g. yes is not read as true.
+func readYAMLParams(path string, b []byte) (map[string]string, error) {
+	var m map[string]interface{}
+	if err := yaml.Unmarshal(b, &m); err != nil {
+		return nil, fmt.Errorf("%s: %w", path, err)
+	}
+	for key, v := range m {
+		if !envKey.MatchString(key) {
+			return nil, fmt.Errorf("%s: invalid key '%s'", path, key)
+		}
+		switch v.(type) {
+		case map[interface{}]interface{}, []interface{}:
+			return nil, fmt.Errorf("%s: value of '%s' is not a scalar", path, key)
+		}
+	}
+	var data map[string]string
+	if err := yaml.Unmarshal(b, &data); err != nil {
+		return nil, fmt.Errorf("%s: %w", path, err)
+	}
+	return data, nil
+}
+
+// withStepNamesFile merges the step names with the ones read from the file,
+// one name per line. Blank lines and lines starting with # are ignored.
+func withStepNamesFile(names []string, path string) ([]string, error) {
+	if path == "" {
+		return names, nil
+	}
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, fmt.Errorf("unable to read step names file: %w", err)
+	}
+	defer f.Close()
+
+	scanner := bufio.NewScanner(f)
+	for scanner.Scan() {
+		line := strings.TrimSpace(scanner.Text())
+		if line == "" || strings.HasPrefix(line, "#") {
+			continue
+		}
+		names = append(names, line)
+	}
+	return names, scanner.Err()
+}
+
+// helper function parses the human readable log size e.g. 10MB,
+// returns -1 if the size is invalid.
+func maxLogSize(size string) int64 {
+	if size == "" {
+		return 0
+	}
+	n, err := units.RAMInBytes(size)
+	if err != nil || n < 0 {
+		return -1
+	}
+	return n
 }
@@ -0,0 +1,70 @@
+package drone
+
+import (
+	"testing"
+	"time"
+)
+
+func TestBuildEventRef(t *testing.T) {
+	tests := []struct {
+		event, ref, tag    string
+		wantEvent, wantRef string
+		wantErr            bool
+	}{
+		{event: "push", ref: "refs/heads/main", wantEvent: "push", wantRef: "refs/heads/main"},
+		{tag: "v1.0.0", wantEvent: "tag", wantRef: "refs/tags/v1.0.0"},
+		{event: "promote", tag: "v1.0.0", wantEvent: "promote", wantRef: "refs/tags/v1.0.0"},
+		{event: "pull_request", wantEvent: "pull_request"},
+		{event: "merge", wantErr: true},
+	}
+	for _, tt := range tests {
+		event, ref, err := buildEventRef(tt.event, tt.ref, tt.tag)
+		if (err != nil) != tt.wantErr {
+			t.Errorf("buildEventRef(%q, %q, %q) error %v", tt.event, tt.ref, tt.tag, err)
+			continue
+		}
+		if event != tt.wantEvent || ref != tt.wantRef {
+			t.Errorf("buildEventRef(%q, %q, %q) = %q, %q, want %q, %q", tt.event, tt.ref, tt.tag, event, ref, tt.wantEvent, tt.wantRef)
+		}
+	}
+}
+
+func TestPullRequestBranches(t *testing.T) {
+	tests := []struct {
+		event, source, target, branch string
+		wantSource, wantTarget        string
+		wantErr                       bool
+	}{
+		{event: "pull_request", source: "feature", target: "main", wantSource: "feature", wantTarget: "main"},
+		{source: "feature", branch: "main", wantSource: "feature", wantTarget: "main"},
+		{event: "push", branch: "main", wantTarget: "main"},
+		{event: "push", source: "feature", wantErr: true},
+	}
+	for _, tt := range tests {
+		source, target, err := pullRequestBranches(tt.event, tt.source, tt.target, tt.branch)
+		if (err != nil) != tt.wantErr {
+			t.Errorf("pullRequestBranches(%q, %q, %q, %q) error %v", tt.event, tt.source, tt.target, tt.branch, err)
+			continue
+		}
+		if source != tt.wantSource || target != tt.wantTarget {
+			t.Errorf("pullRequestBranches(%q, %q, %q, %q) = %q, %q, want %q, %q", tt.event, tt.source, tt.target, tt.branch, source, target, tt.wantSource, tt.wantTarget)
+		}
+	}
+}
+
+func TestTimeoutMinutes(t *testing.T) {
+	tests := []struct {
+		timeout time.Duration
+		want    int64
+	}{
+		{time.Hour, 60},
+		{90 * time.Second, 2},
+		{time.Second, 1},
+		{0, 0},
+	}
+	for _, tt := range tests {
+		if got := timeoutMinutes(tt.timeout); got != tt.want {
+			t.Errorf("timeoutMinutes(%s) = %d, want %d", tt.timeout, got, tt.want)
+		}
+	}
+}
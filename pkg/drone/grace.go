@@ -0,0 +1,79 @@
+package drone
+
+import (
+	"context"
+	"io"
+	"sync"
+
+	"github.com/drone-runners/drone-runner-docker/engine"
+	"github.com/drone/runner-go/pipeline/runtime"
+)
+
+// statusTimedOut is the run summary status of a build stopped by its timeout
+const statusTimedOut = "timed_out"
+
+// graceEngine is a pipeline engine that gives the running steps a grace
+// period on the build timeout, the running steps receive SIGTERM and are
+// force-removed only once the grace period is over.
+type graceEngine struct {
+	runtime.Engine
+	mu       sync.Mutex
+	running  map[string]bool
+	timedOut bool
+}
+
+var _ runtime.Engine = (*graceEngine)(nil)
+
+func newGraceEngine(eng runtime.Engine) *graceEngine {
+	return &graceEngine{
+		Engine:  eng,
+		running: map[string]bool{},
+	}
+}
+
+// Run implements runtime.Engine, the steps starting after the timeout are cancelled
+func (g *graceEngine) Run(ctx context.Context, spec runtime.Spec, stepv runtime.Step, output io.Writer) (*runtime.State, error) {
+	step, ok := stepv.(*engine.Step)
+	if !ok {
+		return g.Engine.Run(ctx, spec, stepv, output)
+	}
+	g.mu.Lock()
+	if g.timedOut {
+		g.mu.Unlock()
+		return nil, context.DeadlineExceeded
+	}
+	g.running[step.ID] = true
+	g.mu.Unlock()
+	defer func() {
+		g.mu.Lock()
+		delete(g.running, step.ID)
+		g.mu.Unlock()
+	}()
+	return g.Engine.Run(ctx, spec, stepv, output)
+}
+
+// terminate marks the build as timed out and sends SIGTERM to the running step containers
+func (g *graceEngine) terminate(ctx context.Context) {
+	g.mu.Lock()
+	g.timedOut = true
+	ids := make([]string, 0, len(g.running))
+	for id := range g.running {
+		ids = append(ids, id)
+	}
+	g.mu.Unlock()
+	if dockerCli == nil {
+		return
+	}
+	for _, id := range ids {
+		if err := dockerCli.ContainerKill(ctx, id, "SIGTERM"); err != nil {
+			log.Debugf("Unable to send SIGTERM to container %s,%v", id, err)
+		}
+	}
+}
+
+// TimedOut tells whether the build timeout fired
+func (g *graceEngine) TimedOut() bool {
+	g.mu.Lock()
+	defer g.mu.Unlock()
+	return g.timedOut
+}
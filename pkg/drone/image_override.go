@@ -0,0 +1,69 @@
+package drone
+
+import (
+	"context"
+	"fmt"
+	"strings"
+
+	"github.com/drone-runners/drone-runner-docker/engine"
+	"github.com/google/go-containerregistry/pkg/crane"
+	"github.com/google/go-containerregistry/pkg/name"
+)
+
+// annotationOverrideOriginal is the material annotation holding the pipeline
+// image that was substituted by the step image with --image-override
+const annotationOverrideOriginal = "override.original"
+
+// parseImageOverrides parses the image substitutions that are defined in
+// --image-override=image=replacement format, keyed by the normalized image.
+func parseImageOverrides(overrides []string) (map[string]string, error) {
+	to := map[string]string{}
+	for _, s := range overrides {
+		parts := strings.SplitN(s, "=", 2)
+		if len(parts) != 2 || parts[0] == "" || parts[1] == "" {
+			return nil, fmt.Errorf("invalid image override '%s', expecting image=replacement", s)
+		}
+		for _, image := range parts {
+			if _, err := name.ParseReference(image); err != nil {
+				return nil, fmt.Errorf("invalid image override '%s' : %w", s, err)
+			}
+		}
+		to[normalizeImage(parts[0])] = parts[1]
+	}
+	return to, nil
+}
+
+// validateImageOverrides checks that the replacement images are present on
+// the local docker daemon or, when not offline, resolvable from their registry.
+func validateImageOverrides(ctx context.Context, overrides map[string]string, offline bool) error {
+	for _, image := range overrides {
+		if dockerCli != nil {
+			if _, _, err := dockerCli.ImageInspectWithRaw(ctx, image); err == nil {
+				continue
+			}
+		}
+		if offline {
+			return fmt.Errorf("image override %s is not present locally", image)
+		}
+		if _, err := crane.Digest(image, craneOptions(ctx)...); err != nil {
+			return fmt.Errorf("image override %s is not resolvable : %w", image, err)
+		}
+	}
+	return nil
+}
+
+// overrideImages substitutes the step images and returns the original
+// images keyed by the normalized replacement image.
+func overrideImages(spec *engine.Spec, overrides map[string]string) map[string]string {
+	originals := map[string]string{}
+	for _, step := range spec.Steps {
+		replacement, ok := overrides[normalizeImage(step.Image)]
+		if !ok {
+			continue
+		}
+		log.Infof("Step %s, image %s overridden by %s", step.Name, step.Image, replacement)
+		originals[normalizeImage(replacement)] = step.Image
+		step.Image = replacement
+	}
+	return originals
+}
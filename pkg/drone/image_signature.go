@@ -0,0 +1,206 @@
+package drone
+
+import (
+	"context"
+	"crypto"
+	"crypto/ecdsa"
+	"crypto/ed25519"
+	"crypto/rsa"
+	"crypto/sha256"
+	"crypto/x509"
+	"encoding/base64"
+	"encoding/json"
+	"encoding/pem"
+	"fmt"
+	"io"
+	"os"
+	"strings"
+
+	"github.com/drone-runners/drone-runner-docker/engine"
+	"github.com/drone/runner-go/pipeline/runtime"
+	"github.com/google/go-containerregistry/pkg/crane"
+	"github.com/google/go-containerregistry/pkg/name"
+)
+
+const (
+	// annotationSignature is the material annotation holding the signature
+	// verification of the image with --verify-images i.e. one of verified or exempt
+	annotationSignature = "signature"
+	// cosignSignatureAnnotation is the layer annotation of the cosign signature
+	cosignSignatureAnnotation = "dev.cosignproject.cosign/signature"
+)
+
+// image signature verifications
+const (
+	signatureVerified = "verified"
+	signatureExempt   = "exempt"
+)
+
+// cosignPayload is the simple signing payload signed by cosign
+type cosignPayload struct {
+	Critical struct {
+		Image struct {
+			DockerManifestDigest string `json:"docker-manifest-digest"`
+		} `json:"image"`
+	} `json:"critical"`
+}
+
+// readPublicKey reads the PEM encoded public key verifying the image signatures
+func readPublicKey(file string) (crypto.PublicKey, error) {
+	b, err := os.ReadFile(file)
+	if err != nil {
+		return nil, fmt.Errorf("unable to read --verify-key: %w", err)
+	}
+	block, _ := pem.Decode(b)
+	if block == nil {
+		return nil, fmt.Errorf("invalid --verify-key %s, expecting a PEM encoded public key", file)
+	}
+	key, err := x509.ParsePKIXPublicKey(block.Bytes)
+	if err != nil {
+		return nil, fmt.Errorf("invalid --verify-key %s: %w", file, err)
+	}
+	return key, nil
+}
+
+// imageVerifier verifies the signature of the image, it returns the verified
+// digest of the image
+type imageVerifier func(ctx context.Context, image string) (string, error)
+
+// keyVerifier returns the image verifier of the cosign signatures signed by the key
+func keyVerifier(key crypto.PublicKey) imageVerifier {
+	return func(ctx context.Context, image string) (string, error) {
+		return verifyImageSignature(ctx, image, key)
+	}
+}
+
+// verifyStepImages verifies the cosign signature of the images of the steps
+// that run, the exempted images are not verified. The verified step images
+// are pinned to their verified digest, so that the images run are the ones
+// verified, and the images before pinning are recorded in the originals keyed
+// by the pinned image. It returns the verification of each image keyed by the
+// normalized pinned image.
+func verifyStepImages(ctx context.Context, spec *engine.Spec, verify imageVerifier, exempt []string, mirror string, originals map[string]string) (map[string]string, error) {
+	verified := map[string]string{}
+	pinned := map[string]string{}
+	for _, s := range spec.Steps {
+		if s.RunPolicy == runtime.RunNever {
+			continue
+		}
+		image := normalizeImage(s.Image)
+		if p, ok := pinned[image]; ok {
+			s.Image = p
+			continue
+		}
+		if _, ok := verified[image]; ok {
+			continue
+		}
+		if isExemptImage(s.Image, exempt) {
+			log.Warnf("Not verifying the signature of the exempted image %s", s.Image)
+			verified[image] = signatureExempt
+			continue
+		}
+		dig, err := verify(ctx, mirrorImage(s.Image, mirror))
+		if err != nil {
+			return nil, fmt.Errorf("step %s: image %s %w", s.Name, s.Image, err)
+		}
+		ref, err := name.ParseReference(s.Image)
+		if err != nil {
+			return nil, fmt.Errorf("step %s: image %s %w", s.Name, s.Image, err)
+		}
+		log.Infof("Verified the signature of image %s, pinned to %s", s.Image, dig)
+		p := ref.Context().Name() + "@" + dig
+		if normalizeImage(p) != image {
+			orig := s.Image
+			if o, ok := originals[image]; ok {
+				orig = o
+			}
+			originals[normalizeImage(p)] = orig
+		}
+		verified[normalizeImage(p)] = signatureVerified
+		pinned[image] = p
+		s.Image = p
+	}
+	return verified, nil
+}
+
+// isExemptImage checks if the image or its repository is in the exemption list
+func isExemptImage(image string, exempt []string) bool {
+	ref, err := name.ParseReference(image)
+	if err != nil {
+		return false
+	}
+	for _, e := range exempt {
+		if normalizeImage(e) == ref.Name() {
+			return true
+		}
+		if r, err := name.NewRepository(e); err == nil && r.Name() == ref.Context().Name() {
+			return true
+		}
+	}
+	return false
+}
+
+// verifyImageSignature verifies that one of the cosign signatures, attached to
+// the image as the sha256-<digest>.sig tag, is signed by the key and signs the
+// image digest. It returns the verified digest.
+func verifyImageSignature(ctx context.Context, image string, key crypto.PublicKey) (string, error) {
+	ref, err := name.ParseReference(image)
+	if err != nil {
+		return "", err
+	}
+	dig, err := crane.Digest(ref.Name(), craneOptions(ctx)...)
+	if err != nil {
+		return "", fmt.Errorf("is not resolvable: %w", err)
+	}
+	sigTag := ref.Context().Tag(strings.Replace(dig, ":", "-", 1) + ".sig")
+	sigs, err := crane.Pull(sigTag.Name(), craneOptions(ctx)...)
+	if err != nil {
+		return "", fmt.Errorf("is not signed, no signature found at %s", sigTag.Name())
+	}
+	m, err := sigs.Manifest()
+	if err != nil {
+		return "", err
+	}
+	layers, err := sigs.Layers()
+	if err != nil {
+		return "", err
+	}
+	for i, l := range m.Layers {
+		sig, err := base64.StdEncoding.DecodeString(l.Annotations[cosignSignatureAnnotation])
+		if err != nil || len(sig) == 0 || i >= len(layers) {
+			continue
+		}
+		rc, err := layers[i].Compressed()
+		if err != nil {
+			return "", err
+		}
+		payload, err := io.ReadAll(rc)
+		rc.Close()
+		if err != nil {
+			return "", err
+		}
+		if !verifySignature(key, payload, sig) {
+			continue
+		}
+		var p cosignPayload
+		if err := json.Unmarshal(payload, &p); err == nil && p.Critical.Image.DockerManifestDigest == dig {
+			return dig, nil
+		}
+	}
+	return "", fmt.Errorf("has no signature of %s verified by --verify-key", dig)
+}
+
+// verifySignature verifies the signature of the payload with the ECDSA, RSA or ed25519 key
+func verifySignature(key crypto.PublicKey, payload, sig []byte) bool {
+	digest := sha256.Sum256(payload)
+	switch k := key.(type) {
+	case *ecdsa.PublicKey:
+		return ecdsa.VerifyASN1(k, digest[:], sig)
+	case *rsa.PublicKey:
+		return rsa.VerifyPKCS1v15(k, crypto.SHA256, digest[:], sig) == nil
+	case ed25519.PublicKey:
+		return ed25519.Verify(k, payload, sig)
+	default:
+		return false
+	}
+}
@@ -0,0 +1,57 @@
+package drone
+
+import (
+	"context"
+	"io"
+	"testing"
+
+	"github.com/drone-runners/drone-runner-docker/engine"
+)
+
+func TestVerifyStepImagesPinsDigest(t *testing.T) {
+	log.SetOutput(io.Discard)
+	const dig = "sha256:0d3f3b0ac7c8b6b3d5c0c3c7a0a5b3a0e2c8f1e7d6c5b4a3f2e1d0c9b8a7f6e5"
+	spec := &engine.Spec{Steps: []*engine.Step{
+		{Name: "build", Image: "busybox"},
+		{Name: "test", Image: "busybox:latest"},
+		{Name: "lint", Image: "golang:1.19"},
+		{Name: "publish", Image: "alpine"},
+	}}
+	// golang:1.19 overrides the original golang image
+	originals := map[string]string{normalizeImage("golang:1.19"): "golang"}
+	verifies := map[string]int{}
+	verify := func(_ context.Context, image string) (string, error) {
+		verifies[image]++
+		return dig, nil
+	}
+	verified, err := verifyStepImages(context.Background(), spec, verify, []string{"alpine"}, "", originals)
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := []string{
+		"index.docker.io/library/busybox@" + dig,
+		"index.docker.io/library/busybox@" + dig,
+		"index.docker.io/library/golang@" + dig,
+		"alpine",
+	}
+	for i, s := range spec.Steps {
+		if s.Image != want[i] {
+			t.Errorf("step %s runs image %s, want %s", s.Name, s.Image, want[i])
+		}
+	}
+	if verifies["busybox"] != 1 {
+		t.Errorf("busybox verified %d times, want once", verifies["busybox"])
+	}
+	if got := originals[want[0]]; got != "busybox" {
+		t.Errorf("busybox pinned from %q, want busybox", got)
+	}
+	if got := originals[want[2]]; got != "golang" {
+		t.Errorf("golang pinned from %q, want the overridden golang", got)
+	}
+	if got := verified[want[0]]; got != signatureVerified {
+		t.Errorf("busybox signature %q, want %s", got, signatureVerified)
+	}
+	if got := verified[normalizeImage("alpine")]; got != signatureExempt {
+		t.Errorf("alpine signature %q, want %s", got, signatureExempt)
+	}
+}
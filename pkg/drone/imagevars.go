@@ -0,0 +1,50 @@
+package drone
+
+import (
+	"regexp"
+	"strings"
+
+	"github.com/drone/envsubst"
+	"github.com/google/go-containerregistry/pkg/name"
+)
+
+// annotationVarPrefix is the prefix of the material annotations that
+// record the variables used to resolve the image reference
+const annotationVarPrefix = "var."
+
+// imageLineRegex matches the image attribute of a step or service
+var imageLineRegex = regexp.MustCompile(`(?m)^\s*(?:-\s*)?image:\s*(.+?)\s*$`)
+
+// imageVariables finds the image references in the raw pipeline source that
+// use substitution variables and returns the variables with their values,
+// keyed by the normalized resolved image reference.
+func imageVariables(rawsource string, subf func(string) string) map[string]map[string]string {
+	imageVars := map[string]map[string]string{}
+	for _, m := range imageLineRegex.FindAllStringSubmatch(rawsource, -1) {
+		raw := strings.Trim(m[1], `"'`)
+		if !strings.Contains(raw, "$") {
+			continue
+		}
+		vars := map[string]string{}
+		resolved, err := envsubst.Eval(raw, func(k string) string {
+			v := subf(k)
+			vars[k] = v
+			return v
+		})
+		if err != nil || len(vars) == 0 {
+			continue
+		}
+		imageVars[normalizeImage(resolved)] = vars
+	}
+	return imageVars
+}
+
+// normalizeImage returns the fully qualified image reference so that
+// the images in the pipeline and the compiled spec can be compared
+func normalizeImage(image string) string {
+	ref, err := name.ParseReference(image)
+	if err != nil {
+		return image
+	}
+	return ref.Name()
+}
@@ -0,0 +1,107 @@
+package drone
+
+import (
+	"crypto/sha256"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"gopkg.in/yaml.v2"
+)
+
+// kindInclude is the kind of the manifest documents that include the
+// documents of another pipeline file e.g.
+//
+//	---
+//	kind: include
+//	path: pipelines/test.yml
+const kindInclude = "include"
+
+// roleInclude is the material role of a pipeline file included by the source
+const roleInclude = "include"
+
+// includedFile is a pipeline file included by the pipeline source
+type includedFile struct {
+	Path   string
+	Digest string
+}
+
+// includeDocument is a manifest document, only the include documents have a path
+type includeDocument struct {
+	Kind string `yaml:"kind"`
+	Path string `yaml:"path"`
+}
+
+// resolveIncludes replaces the include documents of the pipeline source file
+// with the documents of the included files, resolved relative to the directory
+// of the file that includes them, the current directory for stdin and the
+// source directory itself for a directory of pipeline files. The included files
+// are returned in include order.
+func resolveIncludes(source, file string) (string, []includedFile, error) {
+	dir := "."
+	including := map[string]bool{}
+	switch {
+	case file == stdinSource:
+	case isSourceDir(file):
+		dir = file
+	default:
+		dir = filepath.Dir(file)
+		including[filepath.Clean(file)] = true
+	}
+	var included []includedFile
+	resolved, err := includeDocuments(source, dir, including, &included)
+	return resolved, included, err
+}
+
+func includeDocuments(source, dir string, including map[string]bool, included *[]includedFile) (string, error) {
+	docs := splitDocuments(source)
+	for i, doc := range docs {
+		var d includeDocument
+		// the documents that are not valid yaml are reported by the manifest parser
+		if err := yaml.Unmarshal([]byte(doc), &d); err != nil || d.Kind != kindInclude {
+			continue
+		}
+		if d.Path == "" {
+			return "", fmt.Errorf("include document without path in %s", dir)
+		}
+		fp := filepath.Clean(filepath.Join(dir, d.Path))
+		if including[fp] {
+			return "", fmt.Errorf("pipeline file %s includes itself", fp)
+		}
+		b, err := os.ReadFile(fp)
+		if os.IsNotExist(err) {
+			return "", fmt.Errorf("included pipeline file %s not found, include paths are relative to %s", d.Path, dir)
+		}
+		if err != nil {
+			return "", fmt.Errorf("unable to read included pipeline file %s: %w", fp, err)
+		}
+		*included = append(*included, includedFile{
+			Path:   fp,
+			Digest: fmt.Sprintf("%x", sha256.Sum256(b)),
+		})
+		including[fp] = true
+		content, err := includeDocuments(string(b), filepath.Dir(fp), including, included)
+		delete(including, fp)
+		if err != nil {
+			return "", err
+		}
+		docs[i] = strings.TrimSuffix(content, "\n") + "\n"
+	}
+	return strings.Join(docs, "---\n"), nil
+}
+
+// splitDocuments splits the yaml source at the --- document separators
+func splitDocuments(source string) []string {
+	var docs []string
+	var b strings.Builder
+	for _, line := range strings.SplitAfter(source, "\n") {
+		if strings.TrimRight(line, " \r\n") == "---" {
+			docs = append(docs, b.String())
+			b.Reset()
+			continue
+		}
+		b.WriteString(line)
+	}
+	return append(docs, b.String())
+}
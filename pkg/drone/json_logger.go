@@ -1,32 +1,53 @@
 package drone
 
 import (
+	"fmt"
 	"io"
 	"strings"
+	"time"
+)
 
-	"github.com/bfontaine/jsons"
+// supported formats of the step log file
+const (
+	logFormatConsole = "console"
+	logFormatJSON    = "json"
+	logFormatPlain   = "plain"
 )
 
+// plainLogf is the plain text log line format with timestamp and step name
+const plainLogf = "%s [%s] %s\n"
+
+// jsonlogger writes the log lines of a step to the log file, the records
+// are not tagged with their stream as the docker engine copies both the
+// stdout and stderr of the step container to the same writer.
 type jsonlogger struct {
 	name   string
 	number int
-	writer *jsons.FileWriter
+	writer *logFileWriter
 	seq    *sequence
+	plain  bool
 }
 
 // Write implements io.WriteCloser
 func (j *jsonlogger) Write(b []byte) (n int, err error) {
-	for _, part := range split(b) {
-		if err := j.writer.Add(
+	parts := split(b)
+	records := make([]interface{}, 0, len(parts))
+	if j.plain {
+		ts := time.Now().UTC().Format(time.RFC3339)
+		for _, part := range parts {
+			records = append(records, fmt.Sprintf(plainLogf, ts, j.name, part))
+		}
+		return len(b), j.writer.AddAll(records...)
+	}
+	for _, part := range parts {
+		records = append(records,
 			map[string]interface{}{
 				"stepNumber": j.number,
 				"stepName":   j.name,
 				"line":       part,
-			}); err != nil {
-			return len(b), err
-		}
+			})
 	}
-	return len(b), nil
+	return len(b), j.writer.AddAll(records...)
 }
 
 // Close implements io.WriteCloser
@@ -0,0 +1,112 @@
+package drone
+
+import (
+	"encoding/xml"
+	"fmt"
+	"os"
+	"time"
+
+	"github.com/drone-runners/drone-runner-docker/engine"
+	"github.com/drone/drone-go/drone"
+	"github.com/drone/runner-go/pipeline"
+	"github.com/drone/runner-go/pipeline/runtime"
+)
+
+// junitTestSuites is the root element of a JUnit report
+type junitTestSuites struct {
+	XMLName xml.Name         `xml:"testsuites"`
+	Suites  []junitTestSuite `xml:"testsuite"`
+}
+
+// junitTestSuite is the pipeline run, each step being a test case
+type junitTestSuite struct {
+	Name     string          `xml:"name,attr"`
+	Tests    int             `xml:"tests,attr"`
+	Failures int             `xml:"failures,attr"`
+	Errors   int             `xml:"errors,attr"`
+	Skipped  int             `xml:"skipped,attr"`
+	Time     string          `xml:"time,attr"`
+	Cases    []junitTestCase `xml:"testcase"`
+}
+
+// junitTestCase is the result of a pipeline step
+type junitTestCase struct {
+	Name      string        `xml:"name,attr"`
+	ClassName string        `xml:"classname,attr"`
+	Time      string        `xml:"time,attr"`
+	Failure   *junitMessage `xml:"failure,omitempty"`
+	Error     *junitMessage `xml:"error,omitempty"`
+	Skipped   *junitMessage `xml:"skipped,omitempty"`
+}
+
+// junitMessage is the failure, error or skipped detail of a test case
+type junitMessage struct {
+	Message string `xml:"message,attr,omitempty"`
+}
+
+// newJUnitReport builds the JUnit report from the pipeline state and the durations
+// recorded during the run, the steps that never run are reported as skipped test cases
+func newJUnitReport(state *pipeline.State, spec *engine.Spec, rec *stateRecorder) *junitTestSuites {
+	state.Lock()
+	defer state.Unlock()
+	suite := junitTestSuite{
+		Name: state.Stage.Name,
+		Time: junitTime(rec.Duration()),
+	}
+	for _, s := range state.Stage.Steps {
+		tc := junitTestCase{
+			Name:      s.Name,
+			ClassName: state.Stage.Name,
+			Time:      junitTime(stepDuration(rec, s.Name)),
+		}
+		switch s.Status {
+		case drone.StatusFailing:
+			tc.Failure = &junitMessage{Message: fmt.Sprintf("exit code %d", s.ExitCode)}
+			suite.Failures++
+		case drone.StatusError:
+			tc.Error = &junitMessage{Message: s.Error}
+			suite.Errors++
+		case drone.StatusKilled:
+			tc.Error = &junitMessage{Message: "cancelled"}
+			suite.Errors++
+		case drone.StatusSkipped:
+			tc.Skipped = &junitMessage{}
+			suite.Skipped++
+		}
+		suite.Cases = append(suite.Cases, tc)
+	}
+	for _, s := range spec.Steps {
+		if s.RunPolicy != runtime.RunNever {
+			continue
+		}
+		suite.Cases = append(suite.Cases, junitTestCase{
+			Name:      s.Name,
+			ClassName: state.Stage.Name,
+			Time:      junitTime(0),
+			Skipped:   &junitMessage{Message: "step never runs"},
+		})
+		suite.Skipped++
+	}
+	suite.Tests = len(suite.Cases)
+	return &junitTestSuites{Suites: []junitTestSuite{suite}}
+}
+
+// junitTime returns the duration in seconds
+func junitTime(d time.Duration) string {
+	return fmt.Sprintf("%.3f", d.Seconds())
+}
+
+// writeJUnit writes the JUnit report as xml to the file
+func writeJUnit(file string, report *junitTestSuites) error {
+	f, err := os.Create(file)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+	if _, err := f.WriteString(xml.Header); err != nil {
+		return err
+	}
+	enc := xml.NewEncoder(f)
+	enc.Indent("", "  ")
+	return enc.Encode(report)
+}
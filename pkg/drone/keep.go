@@ -0,0 +1,76 @@
+package drone
+
+import (
+	"context"
+	"strings"
+
+	"github.com/drone-runners/drone-runner-docker/engine"
+	"github.com/drone/runner-go/pipeline/runtime"
+)
+
+// keepEngine is a pipeline engine that keeps the workspace volumes and/or the
+// step containers when the pipeline environment is destroyed, so that they
+// can be inspected after the run.
+type keepEngine struct {
+	runtime.Engine
+	workspace  bool
+	containers bool
+}
+
+var _ runtime.Engine = (*keepEngine)(nil)
+
+// Destroy implements runtime.Engine
+func (k *keepEngine) Destroy(ctx context.Context, specv runtime.Spec) error {
+	spec, ok := specv.(*engine.Spec)
+	if !ok {
+		return k.Engine.Destroy(ctx, specv)
+	}
+	destroy := *spec
+	if k.containers {
+		destroy.Steps = nil
+		destroy.Internal = nil
+	}
+	var kept []*engine.VolumeEmptyDir
+	if k.workspace {
+		destroy.Volumes = nil
+		for _, v := range spec.Volumes {
+			if v.EmptyDir != nil && (v.EmptyDir.Name == workspaceVolumeName || v.EmptyDir.Name == outputVolumeName) {
+				kept = append(kept, v.EmptyDir)
+				continue
+			}
+			destroy.Volumes = append(destroy.Volumes, v)
+		}
+	}
+	err := k.Engine.Destroy(ctx, &destroy)
+	k.report(ctx, spec, kept)
+	return err
+}
+
+// report logs the kept resources and how to remove them
+func (k *keepEngine) report(ctx context.Context, spec *engine.Spec, kept []*engine.VolumeEmptyDir) {
+	if k.workspace {
+		for _, v := range spec.Volumes {
+			if v.HostPath != nil && v.HostPath.Name == workspaceVolumeName {
+				log.Infof("Workspace is the host directory %s", v.HostPath.Path)
+			}
+		}
+		for _, v := range kept {
+			mountpoint := ""
+			if dockerCli != nil {
+				if vol, err := dockerCli.VolumeInspect(ctx, v.ID); err == nil {
+					mountpoint = vol.Mountpoint
+				}
+			}
+			log.Infof("Kept volume %s (%s) at %s, inspect it with: docker run --rm -it -v %s:/drone/src busybox sh, remove it with: docker volume rm %s",
+				v.ID, v.Name, mountpoint, v.ID, v.ID)
+		}
+	}
+	if k.containers {
+		var ids []string
+		for _, s := range append(spec.Steps, spec.Internal...) {
+			ids = append(ids, s.ID)
+		}
+		log.Infof("Kept step containers, remove them with: docker rm -f %s && docker network rm %s",
+			strings.Join(ids, " "), spec.Network.ID)
+	}
+}
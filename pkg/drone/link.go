@@ -0,0 +1,37 @@
+package drone
+
+import (
+	"github.com/drone-runners/drone-runner-docker/engine/resource"
+	intoto "github.com/in-toto/in-toto-golang/in_toto"
+)
+
+// supported attestation types
+const (
+	attestationProvenance = "provenance"
+	attestationLink       = "link"
+)
+
+// linkMetablock builds an unsigned in-toto link for the pipeline, the
+// step images are the materials and the subjects are the products.
+func linkMetablock(p *resource.Pipeline, mat []material, subjects []intoto.Subject) intoto.Metablock {
+	materials := make(map[string]interface{}, len(mat))
+	for _, m := range mat {
+		materials[m.URI] = m.Digest
+	}
+	products := make(map[string]interface{}, len(subjects))
+	for _, s := range subjects {
+		products[s.Name] = s.Digest
+	}
+	return intoto.Metablock{
+		Signed: intoto.Link{
+			Type:        "link",
+			Name:        p.Name,
+			Materials:   materials,
+			Products:    products,
+			ByProducts:  map[string]interface{}{},
+			Command:     []string{},
+			Environment: map[string]interface{}{},
+		},
+		Signatures: []intoto.Signature{},
+	}
+}
@@ -0,0 +1,296 @@
+package drone
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"strings"
+
+	"github.com/drone-runners/drone-runner-docker/engine/linter"
+	"github.com/drone-runners/drone-runner-docker/engine/resource"
+	"github.com/drone/drone-go/drone"
+	"github.com/google/go-containerregistry/pkg/name"
+	"github.com/urfave/cli/v2"
+)
+
+// lint finding severities
+const (
+	severityError   = "error"
+	severityWarning = "warning"
+)
+
+// lintFinding is a single lint rule violation of a pipeline, of its step or
+// volume when known, at its position in the pipeline file when it is found
+type lintFinding struct {
+	Pipeline string `json:"pipeline"`
+	Step     string `json:"step,omitempty"`
+	Volume   string `json:"volume,omitempty"`
+	Rule     string `json:"rule"`
+	Message  string `json:"message"`
+	Severity string `json:"severity"`
+	File     string `json:"file,omitempty"`
+	Line     int    `json:"line,omitempty"`
+	Column   int    `json:"column,omitempty"`
+}
+
+// lintRules maps the linter error messages to the rule identifiers
+var lintRules = []struct {
+	match string
+	rule  string
+}{
+	{"duplicate step names", "duplicate-step-name"},
+	{"nil step", "nil-step"},
+	{"missing image", "missing-image"},
+	{"privileged mode", "untrusted-privileged"},
+	{"mount devices", "untrusted-devices"},
+	{"configure dns_search", "untrusted-dns-search"},
+	{"configure dns", "untrusted-dns"},
+	{"configure extra_hosts", "untrusted-extra-hosts"},
+	{"configure network_mode", "untrusted-network-mode"},
+	{"configure shm_size", "untrusted-shm-size"},
+	{"mount host volumes", "untrusted-volume"},
+	{"in-memory volumes", "untrusted-memory-volume"},
+	{"invalid volume name", "invalid-volume-name"},
+	{"missing volume name", "missing-volume-name"},
+	{"/run/drone", "restricted-mount-path"},
+	{"unknown step dependency", "unknown-dependency"},
+	{"cyclical step dependency", "cyclical-dependency"},
+}
+
+// lintRule returns the rule identifier of the linter error
+func lintRule(err error) string {
+	if errors.Is(err, linter.ErrDuplicateStepName) {
+		return "duplicate-step-name"
+	}
+	for _, r := range lintRules {
+		if strings.Contains(err.Error(), r.match) {
+			return r.rule
+		}
+	}
+	return otherLintRule
+}
+
+// otherLintRule is the rule identifier of the linter errors without a known rule
+const otherLintRule = "lint"
+
+// latestImageRule is the rule identifier of the images that are not pinned
+const latestImageRule = "latest-image"
+
+// validateLintRules checks that the rules are known rule identifiers
+func validateLintRules(rules []string) error {
+	known := []string{otherLintRule, latestImageRule}
+	for _, r := range lintRules {
+		known = append(known, r.rule)
+	}
+R:
+	for _, rule := range rules {
+		for _, k := range known {
+			if rule == k {
+				continue R
+			}
+		}
+		return fmt.Errorf("unknown lint rule '%s', expecting one of %v", rule, known)
+	}
+	return nil
+}
+
+// lintFindings lints each step, service and volume of the pipeline on its own
+// so that all the violations are found, instead of the first one returned by
+// the drone linter. The steps are linted after stubs of the preceding steps,
+// which the name and dependency rules are checked against; only the first
+// violation of each step or volume is found.
+func lintFindings(p *resource.Pipeline, repo *drone.Repo) []lintFinding {
+	var findings []lintFinding
+	check := func(one *resource.Pipeline, step, volume string) {
+		if err := linter.New().Lint(one, repo); err != nil {
+			findings = append(findings, lintFinding{
+				Pipeline: p.Name,
+				Step:     step,
+				Volume:   volume,
+				Rule:     lintRule(err),
+				Message:  strings.TrimPrefix(err.Error(), "linter: "),
+				Severity: severityError,
+			})
+		}
+	}
+	var preceding []*resource.Step
+	for _, s := range append(append([]*resource.Step{}, p.Services...), p.Steps...) {
+		one := *p
+		one.Services = nil
+		one.Volumes = nil
+		one.Steps = append(append([]*resource.Step{}, preceding...), s)
+		step := ""
+		if s != nil {
+			step = s.Name
+		}
+		check(&one, step, "")
+		if s != nil {
+			preceding = append(preceding, &resource.Step{Name: s.Name, Image: "scratch"})
+		}
+	}
+	for _, v := range p.Volumes {
+		one := *p
+		one.Services = nil
+		one.Steps = nil
+		one.Volumes = []*resource.Volume{v}
+		volume := ""
+		if v != nil {
+			volume = v.Name
+		}
+		check(&one, "", volume)
+	}
+	return findings
+}
+
+// lintFails tells whether the finding fails the lint, only the selected rules
+// fail with --fail-on-lint-rule, otherwise the errors and, in strict mode,
+// the warnings fail.
+func lintFails(f lintFinding, strict bool, failOn []string) bool {
+	if len(failOn) > 0 {
+		for _, r := range failOn {
+			if f.Rule == r {
+				return true
+			}
+		}
+		return false
+	}
+	return f.Severity == severityError || strict
+}
+
+// lintWarnings checks the pipeline hygiene rules that are not enforced by
+// the drone linter e.g. images that are not pinned to a version, which
+// makes the build and its provenance non reproducible.
+func lintWarnings(p *resource.Pipeline) []lintFinding {
+	var findings []lintFinding
+	for _, s := range append(append([]*resource.Step{}, p.Services...), p.Steps...) {
+		if s == nil || s.Image == "" {
+			continue
+		}
+		ref, err := name.ParseReference(s.Image)
+		if err != nil {
+			continue
+		}
+		if t, ok := ref.(name.Tag); ok && t.TagStr() == name.DefaultTag {
+			findings = append(findings, lintFinding{
+				Pipeline: p.Name,
+				Step:     s.Name,
+				Rule:     latestImageRule,
+				Message:  fmt.Sprintf("step %s image %s is not pinned to a tag or digest", s.Name, s.Image),
+				Severity: severityWarning,
+			})
+		}
+	}
+	return findings
+}
+
+// failOnLintRuleFlag is the flag selecting the lint rules that fail the lint
+func failOnLintRuleFlag() cli.Flag {
+	return &cli.StringSliceFlag{
+		Name:  "fail-on-lint-rule",
+		Usage: "lint rule that fails the lint e.g. untrusted-volume, the findings of the other rules are warnings",
+	}
+}
+
+// LintCommand exports the lint command.
+var LintCommand = &cli.Command{
+	Name:      "lint",
+	Usage:     "lint the pipelines",
+	ArgsUsage: "[path/to/.drone.yml]",
+	Flags: []cli.Flag{
+		&cli.StringFlag{
+			Name:  "pipeline",
+			Usage: "Name of the pipeline to lint, defaults to all the pipelines",
+		},
+		&cli.BoolFlag{
+			Name:  "trusted",
+			Usage: "build is trusted",
+		},
+		&cli.BoolFlag{
+			Name:  "lint-strict",
+			Usage: "treat lint warnings as errors",
+		},
+		failOnLintRuleFlag(),
+		outputFlag(),
+	},
+	Action: lint,
+}
+
+func lint(cliContext *cli.Context) error {
+	output := cliContext.String("output")
+	if err := validateOutput(output); err != nil {
+		return err
+	}
+	commy, err := toExecCommand(cliContext)
+	if err != nil {
+		return err
+	}
+	if err := validateLintRules(commy.FailOnLintRules); err != nil {
+		return err
+	}
+	if commy.LintStrict && len(commy.FailOnLintRules) > 0 {
+		return fmt.Errorf("--lint-strict and --fail-on-lint-rule are mutually exclusive")
+	}
+	manifest, err := parseManifest(cliContext, commy)
+	if err != nil {
+		return err
+	}
+
+	findings := []lintFinding{}
+	for _, r := range manifest.Resources {
+		p, ok := r.(*resource.Pipeline)
+		if !ok {
+			continue
+		}
+		if commy.Stage.Name != "" && p.Name != commy.Stage.Name {
+			continue
+		}
+		findings = append(findings, lintFindings(p, commy.Repo)...)
+		findings = append(findings, lintWarnings(p)...)
+	}
+	// the positions are found in the pipeline file and its included files
+	var files []string
+	if commy.Source != stdinSource && !isSourceDir(commy.Source) {
+		files = append(files, commy.Source)
+	}
+	for _, inc := range commy.Includes {
+		files = append(files, inc.Path)
+	}
+	locateFindings(findings, lintPositions(files))
+	// with --fail-on-lint-rule the severity is the one of the selected rules
+	if len(commy.FailOnLintRules) > 0 {
+		for i := range findings {
+			findings[i].Severity = severityWarning
+			if lintFails(findings[i], false, commy.FailOnLintRules) {
+				findings[i].Severity = severityError
+			}
+		}
+	}
+
+	switch output {
+	case outputJSON:
+		enc := json.NewEncoder(os.Stdout)
+		enc.SetIndent("", "  ")
+		if err := enc.Encode(findings); err != nil {
+			return err
+		}
+	default:
+		for _, f := range findings {
+			if f.File != "" {
+				fmt.Printf("%s:%d:%d: ", f.File, f.Line, f.Column)
+			}
+			fmt.Printf("%s: [%s] %s (%s)\n", f.Pipeline, f.Severity, f.Message, f.Rule)
+		}
+	}
+
+	errs := 0
+	for _, f := range findings {
+		if lintFails(f, commy.LintStrict, commy.FailOnLintRules) {
+			errs++
+		}
+	}
+	if errs > 0 {
+		return withKind(ErrLint, fmt.Errorf("%d lint error(s) found", errs))
+	}
+	return nil
+}
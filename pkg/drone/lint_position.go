@@ -0,0 +1,148 @@
+package drone
+
+import (
+	"bufio"
+	"os"
+	"regexp"
+	"strings"
+)
+
+// lintPosition is the position of a lint finding in a pipeline file, the
+// line and column start at 1
+type lintPosition struct {
+	File   string
+	Line   int
+	Column int
+}
+
+// pipelinePositions are the positions of a pipeline, its steps and services,
+// and its volumes
+type pipelinePositions struct {
+	pipeline lintPosition
+	steps    map[string]lintPosition
+	volumes  map[string]lintPosition
+}
+
+var (
+	// topLevelKey matches the top level keys of a document e.g. steps:
+	topLevelKey = regexp.MustCompile(`^([a-z_]+):`)
+	// nameKey matches the name keys of a document and of its list items
+	nameKey = regexp.MustCompile(`^(\s*)(-\s+)?name:\s*(.*)$`)
+	// listItem matches the list items
+	listItem = regexp.MustCompile(`^(\s*)-\s`)
+)
+
+// lintPositions scans the pipeline files for the positions of the pipelines,
+// keyed by the pipeline name. The yaml parser does not keep the positions, the
+// positions are hence found by matching the name keys of the documents, the
+// first file defining a pipeline wins.
+func lintPositions(files []string) map[string]*pipelinePositions {
+	positions := map[string]*pipelinePositions{}
+	for _, f := range files {
+		if err := scanPositions(f, positions); err != nil {
+			log.Debugf("Unable to find the lint positions in %s,%v", f, err)
+		}
+	}
+	return positions
+}
+
+func scanPositions(file string, positions map[string]*pipelinePositions) error {
+	fh, err := os.Open(file)
+	if err != nil {
+		return err
+	}
+	defer fh.Close()
+	var (
+		doc        *pipelinePositions
+		section    string
+		itemIndent = -1
+		inItem     bool
+	)
+	// the documents are added once their pipeline name is known
+	newDoc := func() {
+		doc = &pipelinePositions{
+			steps:   map[string]lintPosition{},
+			volumes: map[string]lintPosition{},
+		}
+		section, itemIndent, inItem = "", -1, false
+	}
+	newDoc()
+	scanner := bufio.NewScanner(fh)
+	for n := 1; scanner.Scan(); n++ {
+		line := strings.TrimRight(scanner.Text(), " \r")
+		if line == "---" {
+			newDoc()
+			continue
+		}
+		if m := topLevelKey.FindStringSubmatch(line); m != nil {
+			section, itemIndent, inItem = m[1], -1, false
+			if section == "name" {
+				name := yamlScalar(strings.TrimPrefix(line, "name:"))
+				if _, ok := positions[name]; !ok && name != "" {
+					doc.pipeline = lintPosition{File: file, Line: n, Column: 1}
+					positions[name] = doc
+				}
+			}
+			continue
+		}
+		if section != "steps" && section != "services" && section != "volumes" {
+			continue
+		}
+		if m := listItem.FindStringSubmatch(line); m != nil {
+			if itemIndent < 0 {
+				itemIndent = len(m[1])
+			}
+			if len(m[1]) == itemIndent {
+				inItem = true
+			}
+		}
+		m := nameKey.FindStringSubmatch(line)
+		if m == nil || !inItem || itemIndent < 0 {
+			continue
+		}
+		// the name of the item, either on the item line or as its key
+		indent := len(m[1])
+		if m[2] == "" && indent != itemIndent+2 || m[2] != "" && indent != itemIndent {
+			continue
+		}
+		inItem = false
+		name := yamlScalar(m[3])
+		pos := lintPosition{File: file, Line: n, Column: indent + len(m[2]) + 1}
+		byName := doc.steps
+		if section == "volumes" {
+			byName = doc.volumes
+		}
+		if _, ok := byName[name]; !ok && name != "" {
+			byName[name] = pos
+		}
+	}
+	return scanner.Err()
+}
+
+// yamlScalar returns the value of a plain or quoted yaml scalar without its comment
+func yamlScalar(v string) string {
+	v = strings.TrimSpace(v)
+	if i := strings.Index(v, " #"); i >= 0 {
+		v = strings.TrimSpace(v[:i])
+	}
+	return strings.Trim(v, `"'`)
+}
+
+// locateFindings sets the file, line and column of the findings, at their step
+// or volume and otherwise at their pipeline, when they can be found
+func locateFindings(findings []lintFinding, positions map[string]*pipelinePositions) {
+	for i := range findings {
+		f := &findings[i]
+		pp, ok := positions[f.Pipeline]
+		if !ok {
+			continue
+		}
+		pos := pp.pipeline
+		if p, ok := pp.steps[f.Step]; ok && f.Step != "" {
+			pos = p
+		} else if p, ok := pp.volumes[f.Volume]; ok && f.Volume != "" {
+			pos = p
+		}
+		f.File, f.Line, f.Column = pos.File, pos.Line, pos.Column
+	}
+}
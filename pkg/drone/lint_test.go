@@ -0,0 +1,87 @@
+package drone
+
+import (
+	"reflect"
+	"strings"
+	"testing"
+
+	"github.com/drone-runners/drone-runner-docker/engine/linter"
+	"github.com/drone-runners/drone-runner-docker/engine/resource"
+	"github.com/drone/drone-go/drone"
+	"github.com/drone/runner-go/manifest"
+)
+
+const hostMountPipeline = `kind: pipeline
+type: docker
+name: default
+
+steps:
+- name: build
+  image: docker:20.10
+  volumes:
+  - name: dockersock
+    path: /var/run/docker.sock
+  commands:
+  - docker build .
+
+volumes:
+- name: dockersock
+  host:
+    path: /var/run/docker.sock
+`
+
+func parseTestPipeline(t *testing.T, config string) *resource.Pipeline {
+	t.Helper()
+	m, err := manifest.ParseString(config)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, r := range m.Resources {
+		if p, ok := r.(*resource.Pipeline); ok {
+			return p
+		}
+	}
+	t.Fatal("no pipeline in the manifest")
+	return nil
+}
+
+func TestLintTrustedHostMount(t *testing.T) {
+	p := parseTestPipeline(t, hostMountPipeline)
+
+	if err := linter.New().Lint(p, &drone.Repo{Trusted: true}); err != nil {
+		t.Errorf("trusted repository, expecting the host mount to be allowed, got %v", err)
+	}
+
+	err := linter.New().Lint(p, &drone.Repo{Trusted: false})
+	if err == nil {
+		t.Fatal("untrusted repository, expecting the host mount to be rejected")
+	}
+	if got, want := lintRule(err), "untrusted-volume"; got != want {
+		t.Errorf("untrusted repository, got rule %s, want %s", got, want)
+	}
+}
+
+func TestLintFindingsHostMount(t *testing.T) {
+	p := parseTestPipeline(t, hostMountPipeline)
+
+	if got := lintFindings(p, &drone.Repo{Trusted: true}); len(got) != 0 {
+		t.Errorf("trusted repository, expecting no findings, got %v", got)
+	}
+
+	got := lintFindings(p, &drone.Repo{Trusted: false})
+	want := []lintFinding{{
+		Pipeline: "default",
+		Volume:   "dockersock",
+		Rule:     "untrusted-volume",
+		Severity: severityError,
+	}}
+	for i := range got {
+		if !strings.Contains(got[i].Message, "mount host volumes") {
+			t.Errorf("unexpected message %q", got[i].Message)
+		}
+		got[i].Message = ""
+	}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("untrusted repository, got findings %+v, want %+v", got, want)
+	}
+}
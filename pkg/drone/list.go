@@ -0,0 +1,90 @@
+package drone
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+
+	"github.com/drone-runners/drone-runner-docker/engine/resource"
+	"github.com/urfave/cli/v2"
+)
+
+// pipelineInfo describes a pipeline and its steps
+type pipelineInfo struct {
+	Name  string     `json:"name"`
+	Kind  string     `json:"kind"`
+	Type  string     `json:"type"`
+	Steps []stepInfo `json:"steps"`
+}
+
+// stepInfo describes a pipeline step
+type stepInfo struct {
+	Name      string   `json:"name"`
+	Image     string   `json:"image"`
+	Service   bool     `json:"service,omitempty"`
+	DependsOn []string `json:"dependsOn,omitempty"`
+}
+
+// ListCommand exports the list command.
+var ListCommand = &cli.Command{
+	Name:      "list",
+	Usage:     "list the pipelines and their steps",
+	ArgsUsage: "[path/to/.drone.yml]",
+	Flags: []cli.Flag{
+		outputFlag(),
+	},
+	Action: list,
+}
+
+func list(cliContext *cli.Context) error {
+	output := cliContext.String("output")
+	if err := validateOutput(output); err != nil {
+		return err
+	}
+	commy, err := toExecCommand(cliContext)
+	if err != nil {
+		return err
+	}
+	manifest, err := parseManifest(cliContext, commy)
+	if err != nil {
+		return err
+	}
+
+	pipelines := []pipelineInfo{}
+	for _, r := range manifest.Resources {
+		p, ok := r.(*resource.Pipeline)
+		if !ok {
+			continue
+		}
+		pi := pipelineInfo{
+			Name:  p.Name,
+			Kind:  p.Kind,
+			Type:  p.Type,
+			Steps: []stepInfo{},
+		}
+		for _, s := range p.Services {
+			pi.Steps = append(pi.Steps, stepInfo{Name: s.Name, Image: s.Image, Service: true})
+		}
+		for _, s := range p.Steps {
+			pi.Steps = append(pi.Steps, stepInfo{Name: s.Name, Image: s.Image, DependsOn: s.DependsOn})
+		}
+		pipelines = append(pipelines, pi)
+	}
+
+	if output == outputJSON {
+		enc := json.NewEncoder(os.Stdout)
+		enc.SetIndent("", "  ")
+		return enc.Encode(pipelines)
+	}
+	for _, p := range pipelines {
+		fmt.Printf("%s (%s/%s)\n", p.Name, p.Kind, p.Type)
+		for _, s := range p.Steps {
+			kind := "step"
+			if s.Service {
+				kind = "service"
+			}
+			fmt.Printf("  - %s [%s] %s\n", s.Name, kind, s.Image)
+		}
+	}
+	return nil
+}
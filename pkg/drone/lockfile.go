@@ -0,0 +1,177 @@
+package drone
+
+import (
+	"bufio"
+	"encoding/base64"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"github.com/in-toto/in-toto-golang/in_toto/slsa_provenance/common"
+)
+
+// supported lockfile types
+const (
+	lockfileAuto  = "auto"
+	lockfileGoSum = "gosum"
+	lockfileNpm   = "npm"
+)
+
+const (
+	// roleDependency is the material role of the dependencies resolved by a lockfile
+	roleDependency = "dependency"
+	// annotationLockfile is the material annotation holding the lockfile of the dependency
+	annotationLockfile = "lockfile"
+	// goSumDigestKey is the DigestSet key that holds the go.sum h1 hash, as is
+	goSumDigestKey = "h1"
+)
+
+// lockfileType returns the type of the lockfile, detected from its
+// file name when the type is auto.
+func lockfileType(file, typ string) (string, error) {
+	switch typ {
+	case lockfileGoSum, lockfileNpm:
+		return typ, nil
+	case lockfileAuto, "":
+		switch filepath.Base(file) {
+		case "go.sum":
+			return lockfileGoSum, nil
+		case "package-lock.json", "npm-shrinkwrap.json":
+			return lockfileNpm, nil
+		}
+		return "", fmt.Errorf("unable to detect the type of lockfile '%s', use --lockfile-type", file)
+	}
+	return "", fmt.Errorf("unsupported lockfile type '%s', expecting one of [%s %s %s]", typ, lockfileAuto, lockfileGoSum, lockfileNpm)
+}
+
+// lockfileMaterials returns the dependencies resolved by the lockfile of the
+// workspace as materials, a missing lockfile yields no materials.
+func lockfileMaterials(dir, file, typ string) ([]material, error) {
+	typ, err := lockfileType(file, typ)
+	if err != nil {
+		return nil, err
+	}
+	fp := file
+	if !filepath.IsAbs(fp) {
+		fp = filepath.Join(dir, file)
+	}
+	f, err := os.Open(fp)
+	if os.IsNotExist(err) {
+		log.Warnf("Lockfile %s not found, no dependency materials recorded", file)
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+
+	var mat []material
+	switch typ {
+	case lockfileGoSum:
+		mat, err = goSumMaterials(f)
+	case lockfileNpm:
+		mat, err = npmMaterials(f)
+	}
+	if err != nil {
+		return nil, fmt.Errorf("unable to parse lockfile %s: %w", file, err)
+	}
+	for i := range mat {
+		mat[i].Annotations = map[string]string{
+			annotationRole:     roleDependency,
+			annotationLockfile: file,
+		}
+	}
+	return mat, nil
+}
+
+// goSumMaterials returns the modules of the go.sum, the go.mod only
+// entries of the modules that are not part of the build are skipped.
+func goSumMaterials(f *os.File) ([]material, error) {
+	var mat []material
+	scanner := bufio.NewScanner(f)
+	for scanner.Scan() {
+		fields := strings.Fields(scanner.Text())
+		if len(fields) != 3 || strings.HasSuffix(fields[1], "/go.mod") {
+			continue
+		}
+		mat = append(mat, material{
+			ProvenanceMaterial: common.ProvenanceMaterial{
+				URI: fmt.Sprintf("pkg:golang/%s@%s", fields[0], fields[1]),
+				Digest: common.DigestSet{
+					goSumDigestKey: strings.TrimPrefix(fields[2], "h1:"),
+				},
+			},
+		})
+	}
+	return mat, scanner.Err()
+}
+
+// npmPackage is a package of the package-lock.json, nested dependencies
+// are only used by the lockfile version 1.
+type npmPackage struct {
+	Version      string                `json:"version"`
+	Resolved     string                `json:"resolved"`
+	Integrity    string                `json:"integrity"`
+	Link         bool                  `json:"link"`
+	Dependencies map[string]npmPackage `json:"dependencies"`
+}
+
+// npmMaterials returns the packages of the package-lock.json, from the
+// packages of the lockfile version 2 and 3 or the dependencies of version 1.
+func npmMaterials(f *os.File) ([]material, error) {
+	var lock struct {
+		Packages     map[string]npmPackage `json:"packages"`
+		Dependencies map[string]npmPackage `json:"dependencies"`
+	}
+	if err := json.NewDecoder(f).Decode(&lock); err != nil {
+		return nil, err
+	}
+	var mat []material
+	if len(lock.Packages) > 0 {
+		for p, pkg := range lock.Packages {
+			// the root package and the linked workspaces are not dependencies
+			i := strings.LastIndex(p, "node_modules/")
+			if i < 0 || pkg.Link {
+				continue
+			}
+			mat = append(mat, npmMaterial(p[i+len("node_modules/"):], pkg))
+		}
+		return mat, nil
+	}
+	var walk func(deps map[string]npmPackage)
+	walk = func(deps map[string]npmPackage) {
+		for name, pkg := range deps {
+			mat = append(mat, npmMaterial(name, pkg))
+			walk(pkg.Dependencies)
+		}
+	}
+	walk(lock.Dependencies)
+	return mat, nil
+}
+
+// npmMaterial returns the npm package as material with the digest of its integrity
+func npmMaterial(name string, pkg npmPackage) material {
+	ds := common.DigestSet{}
+	for _, sri := range strings.Fields(pkg.Integrity) {
+		parts := strings.SplitN(sri, "-", 2)
+		if len(parts) != 2 {
+			continue
+		}
+		if b, err := base64.StdEncoding.DecodeString(parts[1]); err == nil {
+			ds[parts[0]] = hex.EncodeToString(b)
+		}
+	}
+	// scoped packages have their @ escaped in package URLs
+	if strings.HasPrefix(name, "@") {
+		name = "%40" + name[1:]
+	}
+	return material{
+		ProvenanceMaterial: common.ProvenanceMaterial{
+			URI:    fmt.Sprintf("pkg:npm/%s@%s", name, pkg.Version),
+			Digest: ds,
+		},
+	}
+}
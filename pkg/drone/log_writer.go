@@ -0,0 +1,129 @@
+package drone
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"io/fs"
+	"os"
+	"path"
+	"strings"
+	"sync"
+
+	"github.com/bfontaine/jsons"
+)
+
+// logFileWriter writes json log records, or plain text lines given as strings,
+// to the log file, rolling over to
+// <name>.1.log, <name>.2.log ... once the current file exceeds the maximum
+// size. A record is never split across files. The writer is shared by the
+// loggers of all the steps and is safe for concurrent use.
+type logFileWriter struct {
+	sync.Mutex
+	logFile string
+	maxSize int64
+	part    int
+	size    int64
+	file    *os.File
+	writer  jsons.Writer
+}
+
+func newLogFileWriter(logFile string, maxSize int64) (*logFileWriter, error) {
+	if err := os.MkdirAll(path.Dir(logFile), 0o755); err != nil {
+		return nil, err
+	}
+	// remove the parts left over by a previous run so that
+	// they are not stitched with the logs of this run
+	for n := 1; ; n++ {
+		err := os.Remove(logPartFile(logFile, n))
+		if errors.Is(err, fs.ErrNotExist) {
+			break
+		}
+		if err != nil {
+			return nil, err
+		}
+	}
+	w := &logFileWriter{
+		logFile: logFile,
+		maxSize: maxSize,
+	}
+	if err := w.open(); err != nil {
+		return nil, err
+	}
+	return w, nil
+}
+
+// AddAll writes the records to the log file without interleaving
+// them with the records written concurrently by the other steps
+func (w *logFileWriter) AddAll(records ...interface{}) error {
+	w.Lock()
+	defer w.Unlock()
+	for _, v := range records {
+		if w.maxSize > 0 && w.size >= w.maxSize {
+			if err := w.file.Close(); err != nil {
+				return err
+			}
+			w.part++
+			if err := w.open(); err != nil {
+				return err
+			}
+		}
+		if line, ok := v.(string); ok {
+			if _, err := io.WriteString(w, line); err != nil {
+				return err
+			}
+			continue
+		}
+		if err := w.writer.Add(v); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// Write implements io.Writer to keep track of the current file size
+func (w *logFileWriter) Write(b []byte) (int, error) {
+	n, err := w.file.Write(b)
+	w.size += int64(n)
+	return n, err
+}
+
+// Close closes the current log file
+func (w *logFileWriter) Close() error {
+	w.Lock()
+	defer w.Unlock()
+	return w.file.Close()
+}
+
+func (w *logFileWriter) open() error {
+	f, err := os.Create(logPartFile(w.logFile, w.part))
+	if err != nil {
+		return err
+	}
+	w.file = f
+	w.size = 0
+	w.writer = jsons.NewWriter(w)
+	return nil
+}
+
+// logPartFile returns the file name of the nth part of the log file,
+// the first part being the log file itself
+func logPartFile(logFile string, n int) string {
+	if n == 0 {
+		return logFile
+	}
+	ext := path.Ext(logFile)
+	return fmt.Sprintf("%s.%d%s", strings.TrimSuffix(logFile, ext), n, ext)
+}
+
+// logFileParts returns the existing parts of the log file in order
+func logFileParts(logFile string) []string {
+	var parts []string
+	for n := 0; ; n++ {
+		p := logPartFile(logFile, n)
+		if _, err := os.Stat(p); err != nil {
+			return parts
+		}
+		parts = append(parts, p)
+	}
+}
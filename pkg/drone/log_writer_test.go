@@ -0,0 +1,83 @@
+package drone
+
+import (
+	"bufio"
+	"encoding/json"
+	"fmt"
+	"os"
+	"path"
+	"strings"
+	"sync"
+	"testing"
+)
+
+func TestLogFileWriterParallelWriters(t *testing.T) {
+	const writers, records = 8, 200
+	type record struct {
+		Writer int    `json:"writer"`
+		Seq    int    `json:"seq"`
+		Pad    string `json:"pad"`
+	}
+	logFile := path.Join(t.TempDir(), "run.log")
+	// a small max size to also write the records across the log parts
+	w, err := newLogFileWriter(logFile, 16*1024)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var wg sync.WaitGroup
+	for n := 0; n < writers; n++ {
+		wg.Add(1)
+		go func(n int) {
+			defer wg.Done()
+			for i := 0; i < records; i += 2 {
+				err := w.AddAll(
+					record{Writer: n, Seq: i, Pad: strings.Repeat("x", 64)},
+					fmt.Sprintf("{\"writer\":%d,\"seq\":%d,\"pad\":\"plain\"}\n", n, i+1),
+				)
+				if err != nil {
+					t.Error(err)
+					return
+				}
+			}
+		}(n)
+	}
+	wg.Wait()
+	if err := w.Close(); err != nil {
+		t.Fatal(err)
+	}
+
+	parts := logFileParts(logFile)
+	if len(parts) < 2 {
+		t.Errorf("expecting the log to be split in parts, got %v", parts)
+	}
+	next := make([]int, writers)
+	for _, p := range parts {
+		f, err := os.Open(p)
+		if err != nil {
+			t.Fatal(err)
+		}
+		s := bufio.NewScanner(f)
+		for s.Scan() {
+			var r record
+			if err := json.Unmarshal(s.Bytes(), &r); err != nil {
+				t.Fatalf("broken line %q in %s, %v", s.Text(), p, err)
+			}
+			if r.Writer < 0 || r.Writer >= writers {
+				t.Fatalf("unexpected record %+v", r)
+			}
+			if r.Seq != next[r.Writer] {
+				t.Fatalf("unexpected record %+v, expecting seq %d", r, next[r.Writer])
+			}
+			next[r.Writer]++
+		}
+		f.Close()
+		if err := s.Err(); err != nil {
+			t.Fatal(err)
+		}
+	}
+	for n, seq := range next {
+		if seq != records {
+			t.Errorf("writer %d, got %d records, want %d", n, seq, records)
+		}
+	}
+}
@@ -0,0 +1,147 @@
+package drone
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"os"
+	"strings"
+
+	"github.com/bfontaine/jsons"
+	"github.com/urfave/cli/v2"
+)
+
+// logRecord is a step log line written by the json log streamer
+type logRecord struct {
+	StepNumber int    `json:"stepNumber"`
+	StepName   string `json:"stepName"`
+	Line       string `json:"line"`
+}
+
+// LogsCommand exports the logs command.
+var LogsCommand = &cli.Command{
+	Name:      "logs",
+	Usage:     "print the step logs of a run, including the rotated log files, the logs of the last run by default",
+	ArgsUsage: "[path/to/stage.log]",
+	Flags: []cli.Flag{
+		&cli.StringFlag{
+			Name:  "step",
+			Usage: "print only the logs of this step",
+		},
+	},
+	Action: logs,
+}
+
+func logs(cliContext *cli.Context) error {
+	logFile := cliContext.Args().First()
+	if logFile == "" {
+		var err error
+		if logFile, err = lastRunLog(); err != nil {
+			return err
+		}
+	}
+	parts := logFileParts(logFile)
+	if len(parts) == 0 {
+		return fmt.Errorf("log file %s not found", logFile)
+	}
+	step := cliContext.String("step")
+	for _, p := range parts {
+		if err := printLogPart(p, step); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// lastRunLog returns the log file of the last run of the run history
+func lastRunLog() (string, error) {
+	history, err := readRunHistory()
+	if err != nil {
+		return "", err
+	}
+	if len(history) == 0 {
+		return "", fmt.Errorf("no runs in the run history %s, pass the log file", runHistoryFile())
+	}
+	r := history[len(history)-1]
+	if r.Log == "" {
+		return "", fmt.Errorf("the last run of stage '%s' of %s has no logs, its step logs were only written to the console", r.Stage, r.Source)
+	}
+	return r.Log, nil
+}
+
+// printLogPart prints the records of a log file part, the plain text parts
+// are printed as is
+func printLogPart(part, step string) error {
+	format, err := logPartFormat(part)
+	if err != nil {
+		return err
+	}
+	if format == logFormatPlain {
+		return printPlainLogPart(part, step)
+	}
+	fr := jsons.NewFileReader(part)
+	if err := fr.Open(); err != nil {
+		return err
+	}
+	defer fr.Close()
+	for {
+		var r logRecord
+		err := fr.Next(&r)
+		if err == io.EOF {
+			return nil
+		}
+		if err != nil {
+			return fmt.Errorf("unable to read log file %s: %w", part, err)
+		}
+		if step != "" && r.StepName != step {
+			continue
+		}
+		fmt.Fprintf(os.Stdout, "[%s:%d] %s\n", r.StepName, r.StepNumber, r.Line)
+	}
+}
+
+// logPartFormat detects the format of the log file part from its first line,
+// the json records are objects while the plain lines start with a timestamp
+func logPartFormat(part string) (string, error) {
+	f, err := os.Open(part)
+	if err != nil {
+		return "", err
+	}
+	defer f.Close()
+	line, err := bufio.NewReader(f).ReadString('\n')
+	if err != nil && err != io.EOF {
+		return "", fmt.Errorf("unable to read log file %s: %w", part, err)
+	}
+	if line == "" || strings.HasPrefix(line, "{") {
+		return logFormatJSON, nil
+	}
+	return logFormatPlain, nil
+}
+
+// printPlainLogPart prints the lines of a plain text log file part, only
+// the lines of the step when set
+func printPlainLogPart(part, step string) error {
+	f, err := os.Open(part)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+	if step == "" {
+		_, err := io.Copy(os.Stdout, f)
+		return err
+	}
+	br := bufio.NewReader(f)
+	for {
+		line, err := br.ReadString('\n')
+		// the lines are formatted with plainLogf i.e. "<timestamp> [<step>] <line>"
+		if i := strings.Index(line, " "); i >= 0 && strings.HasPrefix(line[i+1:], "["+step+"] ") {
+			fmt.Fprint(os.Stdout, line)
+		}
+		if err == io.EOF {
+			return nil
+		}
+		if err != nil {
+			return fmt.Errorf("unable to read log file %s: %w", part, err)
+		}
+	}
+}
@@ -0,0 +1,36 @@
+package drone
+
+import (
+	"os"
+	"path"
+	"strings"
+	"testing"
+)
+
+func TestLastRunLog(t *testing.T) {
+	droneCILogsDir = t.TempDir()
+	if _, err := lastRunLog(); err == nil || !strings.Contains(err.Error(), "no runs") {
+		t.Errorf("expecting no runs in the history, %v", err)
+	}
+
+	if _, err := runStubExec(t, &stubEngine{}, testPipeline, "--include", "build"); err != nil {
+		t.Fatal(err)
+	}
+	logFile, err := lastRunLog()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if path.Dir(logFile) != droneCILogsDir {
+		t.Errorf("log file %s, want under %s", logFile, droneCILogsDir)
+	}
+	if _, err := os.Stat(logFile); err != nil {
+		t.Error(err)
+	}
+
+	if _, err := runStubExec(t, &stubEngine{}, testPipeline, "--include", "build", "--log-format", logFormatConsole); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := lastRunLog(); err == nil || !strings.Contains(err.Error(), "has no logs") {
+		t.Errorf("expecting the last run to have no logs, %v", err)
+	}
+}
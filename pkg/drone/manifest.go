@@ -0,0 +1,178 @@
+package drone
+
+import (
+	"crypto/sha256"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+
+	"github.com/drone-runners/drone-runner-docker/engine/resource"
+	"github.com/drone/envsubst"
+	"github.com/drone/runner-go/environ"
+	"github.com/drone/runner-go/manifest"
+	"github.com/urfave/cli/v2"
+)
+
+const (
+	// stdinSource is the pipeline source argument to read the pipeline from stdin
+	stdinSource = "-"
+	// stdinSourceURI is the provenance material URI of the pipeline read from stdin
+	stdinSourceURI = "stdin"
+)
+
+// pipelineFilePatterns match the pipeline files of a source directory
+var pipelineFilePatterns = []string{"*.drone.yml", "*.drone.yaml"}
+
+// readSource reads the pipeline from the file or stdin when the source is "-".
+// The pipeline of a directory includes all the pipeline files of the directory.
+func readSource(source string) ([]byte, error) {
+	if source == stdinSource {
+		return ioutil.ReadAll(os.Stdin)
+	}
+	if !isSourceDir(source) {
+		return ioutil.ReadFile(source)
+	}
+	files, err := pipelineFiles(source)
+	if err != nil {
+		return nil, err
+	}
+	var b strings.Builder
+	for _, f := range files {
+		fmt.Fprintf(&b, "---\nkind: %s\npath: %s\n", kindInclude, filepath.Base(f))
+	}
+	return []byte(b.String()), nil
+}
+
+// isSourceDir checks if the pipeline source is a directory of pipeline files
+func isSourceDir(source string) bool {
+	fi, err := os.Stat(source)
+	return err == nil && fi.IsDir()
+}
+
+// pipelineFiles returns the pipeline files of the directory sorted by name
+func pipelineFiles(dir string) ([]string, error) {
+	var files []string
+	for _, p := range pipelineFilePatterns {
+		m, err := filepath.Glob(filepath.Join(dir, p))
+		if err != nil {
+			return nil, err
+		}
+		files = append(files, m...)
+	}
+	if len(files) == 0 {
+		return nil, fmt.Errorf("no pipeline files %v found in directory %s", pipelineFilePatterns, dir)
+	}
+	sort.Strings(files)
+	return files, nil
+}
+
+// parseManifest reads the pipeline source, evaluates the string
+// substitutions and parses the configuration.
+func parseManifest(cliContext *cli.Context, commy *execCommand) (*manifest.Manifest, error) {
+	rawsource, err := readSource(commy.Source)
+	if err != nil {
+		return nil, err
+	}
+	commy.SourceDigest = fmt.Sprintf("%x", sha256.Sum256(rawsource))
+	source, includes, err := resolveIncludes(string(rawsource), commy.Source)
+	if err != nil {
+		return nil, err
+	}
+	commy.Includes = includes
+	envs := pipelineEnvs(cliContext, commy.Flags)
+
+	commy.Envs = envs
+
+	subf := substFunc(envs)
+
+	// evaluates string replacement expressions and returns an
+	// update configuration.
+	config, err := envsubst.Eval(source, subf)
+	if err != nil {
+		return nil, err
+	}
+	// record the variables that were used to resolve the step images
+	commy.ImageVars = imageVariables(source, subf)
+	if commy.SubjectFrom != "" {
+		if commy.SubjectFrom, err = envsubst.Eval(commy.SubjectFrom, subf); err != nil {
+			return nil, err
+		}
+	}
+
+	return manifest.ParseString(config)
+}
+
+// pipelineEnvs returns the variables substituted in the pipeline source
+func pipelineEnvs(cliContext *cli.Context, flags *Flags) map[string]string {
+	return environ.Combine(
+		getEnv(cliContext),
+		environ.System(flags.System),
+		environ.Repo(flags.Repo),
+		environ.Build(flags.Build),
+		environ.Stage(flags.Stage),
+		environ.Link(flags.Repo, flags.Build, flags.System),
+		flags.Build.Params,
+	)
+}
+
+// substFunc returns the string substitution function of the variables, it
+// ensures that string replacement variables are escaped and quoted if they
+// contain newlines.
+func substFunc(envs map[string]string) func(string) string {
+	return func(k string) string {
+		v := envs[k]
+		if strings.Contains(v, "\n") {
+			v = fmt.Sprintf("%q", v)
+		}
+		return v
+	}
+}
+
+// expandFlagValues expands the variables of the flag values like in the
+// pipeline source, the variables that are not pipeline variables e.g. HOME
+// are looked up in the environment.
+func expandFlagValues(values []string, envs map[string]string) ([]string, error) {
+	subf := substFunc(envs)
+	expanded := make([]string, 0, len(values))
+	for _, v := range values {
+		e, err := envsubst.Eval(v, func(k string) string {
+			if _, ok := envs[k]; ok {
+				return subf(k)
+			}
+			return os.Getenv(k)
+		})
+		if err != nil {
+			return nil, fmt.Errorf("unable to expand '%s': %w", v, err)
+		}
+		expanded = append(expanded, e)
+	}
+	return expanded, nil
+}
+
+// lookupPipeline returns the pipeline to run, either by its position, starting
+// at 1, among the pipelines of the manifest or by the stage name. The stage name
+// is set to the name of the pipeline found by position.
+func lookupPipeline(commy *execCommand, m *manifest.Manifest) (manifest.Resource, error) {
+	if commy.PipelineIndex == 0 {
+		res, err := resource.Lookup(commy.Stage.Name, m)
+		if err != nil {
+			return nil, withKind(ErrStageNotFound, fmt.Errorf("stage '%s' not found in build file : %w", commy.Stage.Name, err))
+		}
+		return res, nil
+	}
+	n := 0
+	for _, r := range m.Resources {
+		if _, ok := r.(*resource.Pipeline); !ok {
+			continue
+		}
+		n++
+		if n == commy.PipelineIndex {
+			commy.Stage.Name = r.GetName()
+			return r, nil
+		}
+	}
+	return nil, withKind(ErrStageNotFound, fmt.Errorf("pipeline index %d not found in build file with %d pipeline(s)", commy.PipelineIndex, n))
+}
@@ -0,0 +1,74 @@
+package drone
+
+import (
+	"fmt"
+	"strings"
+
+	"github.com/google/go-containerregistry/pkg/name"
+)
+
+// supported URI schemes of the image materials
+const (
+	// materialSchemePkg is e.g. pkg:alpine:3.16@sha256:...
+	materialSchemePkg = "pkg"
+	// materialSchemePURL is the package URL e.g. pkg:docker/library/alpine@sha256:...
+	materialSchemePURL = "purl"
+	// materialSchemeOCI is the OCI reference e.g. index.docker.io/library/alpine@sha256:...
+	materialSchemeOCI = "oci"
+)
+
+func validateMaterialURIScheme(scheme string) error {
+	switch scheme {
+	case materialSchemePkg, materialSchemePURL, materialSchemeOCI:
+		return nil
+	}
+	return fmt.Errorf("unsupported material URI scheme '%s', expecting one of [%s %s %s]", scheme, materialSchemePkg, materialSchemePURL, materialSchemeOCI)
+}
+
+// withMaterialURIScheme returns the materials with the URIs of the images in
+// the scheme, the other materials and the digest sets are kept as is. As the
+// purl and OCI URIs do not have the image tag, the images resolving to the
+// same URI are recorded once.
+func withMaterialURIScheme(mat []material, scheme string) []material {
+	if scheme == materialSchemePkg {
+		return mat
+	}
+	seen := map[string]bool{}
+	schemed := make([]material, 0, len(mat))
+	for _, m := range mat {
+		if hasRole(m, roleStep, roleService, roleClone) {
+			m.URI = imageMaterialURI(strings.TrimPrefix(materialKey(m.URI), "pkg:"), m.Digest["sha256"], scheme)
+			if seen[m.URI] {
+				continue
+			}
+			seen[m.URI] = true
+		}
+		schemed = append(schemed, m)
+	}
+	return schemed
+}
+
+// imageMaterialURI returns the URI of the image with its digest in the scheme
+func imageMaterialURI(image, digest, scheme string) string {
+	ref, err := name.ParseReference(image)
+	if err != nil {
+		return pkgImageURI(image, digest)
+	}
+	switch scheme {
+	case materialSchemePURL:
+		if digest != "" {
+			return imagePURL(image, digest)
+		}
+		purl := "pkg:docker/" + ref.Context().RepositoryStr()
+		if ref.Context().RegistryStr() != name.DefaultRegistry {
+			purl += "?repository_url=" + ref.Context().Name()
+		}
+		return purl
+	case materialSchemeOCI:
+		if digest == "" {
+			return ref.Name()
+		}
+		return ref.Context().Name() + "@sha256:" + digest
+	}
+	return pkgImageURI(image, digest)
+}
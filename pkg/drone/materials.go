@@ -0,0 +1,378 @@
+package drone
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"runtime"
+	"sort"
+	"strconv"
+	"strings"
+
+	"github.com/drone-runners/drone-runner-docker/engine"
+	"github.com/google/go-containerregistry/pkg/crane"
+	"github.com/google/go-containerregistry/pkg/name"
+	v1 "github.com/google/go-containerregistry/pkg/v1"
+	"github.com/in-toto/in-toto-golang/in_toto/slsa_provenance/common"
+	slsa "github.com/in-toto/in-toto-golang/in_toto/slsa_provenance/v0.2"
+)
+
+const (
+	// indexDigestKey is the DigestSet key that holds the digest of the
+	// manifest list when the step image is a multi-arch image
+	indexDigestKey = "index-sha256"
+	// annotationRole is the material annotation holding the role of the
+	// image in the pipeline i.e. one of step, service or clone
+	annotationRole = "role"
+	// annotationResolution is the material annotation holding how the image
+	// digest was resolved in offline mode i.e. one of local or unresolved
+	annotationResolution = "resolution"
+	// annotationStep is the material annotation holding the name of the
+	// step that introduced the image
+	annotationStep = "step"
+	// annotationStepNumber is the material annotation holding the number
+	// of the step that introduced the image
+	annotationStepNumber = "step.number"
+)
+
+// image digest resolutions in offline mode
+const (
+	resolutionLocal      = "local"
+	resolutionUnresolved = "unresolved"
+)
+
+// material roles
+const (
+	roleStep    = "step"
+	roleService = "service"
+	roleClone   = "clone"
+	roleSource  = "source"
+)
+
+// material is a provenance material with annotations that describe
+// how the material was used by the pipeline
+type material struct {
+	common.ProvenanceMaterial
+	Annotations map[string]string `json:"annotations,omitempty"`
+}
+
+// provenancePredicate is the SLSA provenance predicate with the
+// versioned builder, the build metadata, the annotated materials
+// and the release of the release builds
+type provenancePredicate struct {
+	slsa.ProvenancePredicate
+	Builder   provenanceBuilder   `json:"builder"`
+	Metadata  *provenanceMetadata `json:"metadata,omitempty"`
+	Materials []material          `json:"materials,omitempty"`
+	Release   *provenanceRelease  `json:"release,omitempty"`
+}
+
+// provenanceMetadata is the SLSA provenance metadata with the trust
+// of the build, trusted builds can use host volumes and privileged mode,
+// where the workspace source came from, whether the run was cancelled
+// i.e. only the completed steps are recorded, and whether it was
+// cancelled by the build timeout
+type provenanceMetadata struct {
+	slsa.ProvenanceMetadata
+	Trusted   bool   `json:"trusted"`
+	Workspace string `json:"workspace"`
+	Cancelled bool   `json:"cancelled,omitempty"`
+	TimedOut  bool   `json:"timedOut,omitempty"`
+}
+
+// sources of the workspace, recorded in the provenance metadata
+const (
+	// workspaceClone is the workspace cloned by the clone step with --clone
+	workspaceClone = "clone"
+	// workspaceMount is the current directory mounted as the workspace
+	workspaceMount = "mount"
+)
+
+// workspaceSource returns where the workspace source came from
+func workspaceSource(clone bool) string {
+	if clone {
+		return workspaceClone
+	}
+	return workspaceMount
+}
+
+func materials(ctx context.Context, commy *execCommand, spec *engine.Spec) []material {
+	var mat []material
+	platform := specPlatform(spec)
+	for _, s := range spec.Steps {
+		if err := ctx.Err(); err != nil {
+			log.Warnf("Provenance materials are incomplete,%v", err)
+			break
+		}
+		role := stepRole(s)
+		if role == roleClone && commy.ExcludeCloneMaterial {
+			continue
+		}
+		// the services are part of the environment, rather than build inputs
+		if role == roleService && !commy.ServiceMaterials {
+			continue
+		}
+		annotations := materialAnnotations(commy, s, role)
+		var ds common.DigestSet
+		var err error
+		// the digest is looked up from the mirror the image was pulled from,
+		// the material keeps the original image
+		image := mirrorImage(s.Image, commy.RegistryMirror)
+		if commy.Offline {
+			ds, err = localImageDigests(ctx, image)
+			annotations[annotationResolution] = resolutionLocal
+		} else {
+			ds, err = imageDigests(ctx, image, platform)
+		}
+		if err != nil {
+			log.Warnf("Unable to resolve digest of image %s,%v", s.Image, err)
+			if commy.Offline {
+				annotations[annotationResolution] = resolutionUnresolved
+			}
+		}
+		mat = append(mat, material{
+			ProvenanceMaterial: common.ProvenanceMaterial{
+				URI:    pkgImageURI(s.Image, ds["sha256"]),
+				Digest: ds,
+			},
+			Annotations: annotations,
+		})
+	}
+	if commy.Lockfile != "" {
+		if commy.Clone {
+			log.Warnln("Skipping --lockfile, the workspace is not mounted from the host when cloning")
+		} else {
+			pwd, _ := os.Getwd()
+			deps, err := lockfileMaterials(pwd, commy.Lockfile, commy.LockfileType)
+			if err != nil {
+				log.Warnf("Unable to record the lockfile dependencies,%v", err)
+			}
+			mat = append(mat, deps...)
+		}
+	}
+	return append(append([]material{sourceMaterial(commy)}, includeMaterials(commy)...), dedupMaterials(mat)...)
+}
+
+// pkgImageURI returns the pkg URI of the image, with its digest when resolved
+func pkgImageURI(image, digest string) string {
+	if digest == "" {
+		return "pkg:" + image
+	}
+	return fmt.Sprintf("pkg:%s@sha256:%s", image, digest)
+}
+
+// includeMaterials returns the materials of the pipeline files included by the source
+func includeMaterials(commy *execCommand) []material {
+	var mat []material
+	for _, inc := range commy.Includes {
+		mat = append(mat, material{
+			ProvenanceMaterial: common.ProvenanceMaterial{
+				URI: "file:" + inc.Path,
+				Digest: common.DigestSet{
+					"sha256": inc.Digest,
+				},
+			},
+			Annotations: map[string]string{
+				annotationRole: roleInclude,
+			},
+		})
+	}
+	return mat
+}
+
+// sourceMaterial returns the pipeline source material with its content digest,
+// the pipeline read from stdin has no path and is recorded with the stdin URI.
+func sourceMaterial(commy *execCommand) material {
+	uri := stdinSourceURI
+	if commy.Source != stdinSource {
+		uri = "file:" + commy.Source
+	}
+	return material{
+		ProvenanceMaterial: common.ProvenanceMaterial{
+			URI: uri,
+			Digest: common.DigestSet{
+				"sha256": commy.SourceDigest,
+			},
+		},
+		Annotations: map[string]string{
+			annotationRole: roleSource,
+		},
+	}
+}
+
+// missingDigests returns the URIs of the materials whose digest was not resolved
+func missingDigests(mat []material) []string {
+	var missing []string
+M:
+	for _, m := range mat {
+		for _, d := range m.Digest {
+			if d != "" {
+				continue M
+			}
+		}
+		missing = append(missing, m.URI)
+	}
+	return missing
+}
+
+// dedupMaterials merges the materials with same URI and sorts them by URI,
+// so that the same pipeline always yields the same provenance.
+func dedupMaterials(mat []material) []material {
+	byURI := make(map[string]*material, len(mat))
+	var uris []string
+	for i := range mat {
+		m := &mat[i]
+		e, ok := byURI[m.URI]
+		if !ok {
+			byURI[m.URI] = m
+			uris = append(uris, m.URI)
+			continue
+		}
+		for k, v := range m.Annotations {
+			e.Annotations[k] = mergeAnnotation(e.Annotations[k], v)
+		}
+	}
+	sort.Strings(uris)
+	deduped := make([]material, 0, len(uris))
+	for _, u := range uris {
+		deduped = append(deduped, *byURI[u])
+	}
+	return deduped
+}
+
+// hasRole tells whether the material has one of the roles, the role of the
+// materials merged by dedupMaterials is the comma separated roles of the
+// merged materials e.g. service,step
+func hasRole(m material, roles ...string) bool {
+	for _, r := range strings.Split(m.Annotations[annotationRole], ",") {
+		for _, role := range roles {
+			if r == role {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+// mergeAnnotation merges the comma separated annotation values a and b
+// into a sorted comma separated list of unique values
+func mergeAnnotation(a, b string) string {
+	set := map[string]struct{}{}
+	for _, v := range strings.Split(a+","+b, ",") {
+		if v != "" {
+			set[v] = struct{}{}
+		}
+	}
+	vals := make([]string, 0, len(set))
+	for v := range set {
+		vals = append(vals, v)
+	}
+	sort.Strings(vals)
+	return strings.Join(vals, ",")
+}
+
+// materialAnnotations returns the annotations of the step image material
+func materialAnnotations(commy *execCommand, s *engine.Step, role string) map[string]string {
+	annotations := map[string]string{
+		annotationRole: role,
+		annotationStep: s.Name,
+	}
+	for _, st := range commy.Stage.Steps {
+		if st.Name == s.Name {
+			annotations[annotationStepNumber] = strconv.Itoa(st.Number)
+			break
+		}
+	}
+	if v, ok := commy.ImageSignatures[normalizeImage(s.Image)]; ok {
+		annotations[annotationSignature] = v
+	}
+	image := s.Image
+	if orig, ok := commy.ImageOriginals[normalizeImage(s.Image)]; ok {
+		annotations[annotationOverrideOriginal] = orig
+		image = orig
+	}
+	for k, v := range commy.ImageVars[normalizeImage(image)] {
+		annotations[annotationVarPrefix+k] = v
+	}
+	return annotations
+}
+
+// stepRole returns the role of the step based on the labels
+// added during exec and the step name
+func stepRole(s *engine.Step) string {
+	switch {
+	case s.Labels[labelService] == "true":
+		return roleService
+	case s.Name == "clone":
+		return roleClone
+	default:
+		return roleStep
+	}
+}
+
+// imageDigests resolves the digest of the image for the platform. When the
+// image is a manifest list the platform specific digest is recorded as "sha256"
+// and the digest of the manifest list as "index-sha256".
+func imageDigests(ctx context.Context, image string, platform *v1.Platform) (common.DigestSet, error) {
+	ds := common.DigestSet{}
+	dig, err := crane.Digest(image, craneOptions(ctx)...)
+	if err != nil {
+		return ds, err
+	}
+	pDig, err := crane.Digest(image, craneOptions(ctx, crane.WithPlatform(platform))...)
+	if err != nil {
+		return ds, err
+	}
+	ds["sha256"] = strings.TrimPrefix(pDig, "sha256:")
+	if pDig != dig {
+		ds[indexDigestKey] = strings.TrimPrefix(dig, "sha256:")
+	}
+	return ds, nil
+}
+
+// localImageDigests resolves the digest of the image from the repository
+// digests known to the local docker daemon, without reaching the registry.
+func localImageDigests(ctx context.Context, image string) (common.DigestSet, error) {
+	ds := common.DigestSet{}
+	if dockerCli == nil {
+		return ds, fmt.Errorf("docker is not reachable")
+	}
+	ref, err := name.ParseReference(image)
+	if err != nil {
+		return ds, err
+	}
+	if d, ok := ref.(name.Digest); ok {
+		ds["sha256"] = strings.TrimPrefix(d.DigestStr(), "sha256:")
+		return ds, nil
+	}
+	ii, _, err := dockerCli.ImageInspectWithRaw(ctx, image)
+	if err != nil {
+		return ds, err
+	}
+	for _, rd := range ii.RepoDigests {
+		d, err := name.NewDigest(rd)
+		if err != nil || d.Context().Name() != ref.Context().Name() {
+			continue
+		}
+		ds["sha256"] = strings.TrimPrefix(d.DigestStr(), "sha256:")
+		return ds, nil
+	}
+	return ds, fmt.Errorf("no repository digest of %s is known locally", image)
+}
+
+// specPlatform returns the platform the pipeline steps run on,
+// defaulting to linux and the architecture of this binary.
+func specPlatform(spec *engine.Spec) *v1.Platform {
+	p := &v1.Platform{
+		OS:           spec.Platform.OS,
+		Architecture: spec.Platform.Arch,
+		Variant:      spec.Platform.Variant,
+	}
+	if p.OS == "" {
+		p.OS = "linux"
+	}
+	if p.Architecture == "" {
+		p.Architecture = runtime.GOARCH
+	}
+	return p
+}
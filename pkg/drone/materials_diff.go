@@ -0,0 +1,141 @@
+package drone
+
+import (
+	"crypto/sha256"
+	"encoding/json"
+	"fmt"
+	"io"
+	"os"
+	"sort"
+	"strings"
+
+	"github.com/in-toto/in-toto-golang/in_toto/slsa_provenance/common"
+)
+
+// annotationChange is the material annotation holding the change of the
+// material since the previous provenance i.e. one of added, changed or unchanged
+const annotationChange = "change"
+
+// material changes since the previous provenance
+const (
+	changeAdded     = "added"
+	changeChanged   = "changed"
+	changeUnchanged = "unchanged"
+)
+
+// roleBaseline is the material role of the previous provenance the unchanged
+// images are referenced by with --only-changed-images
+const roleBaseline = "baseline"
+
+// annotationUnchanged is the material annotation holding the number of the
+// unchanged images referenced by the previous provenance
+const annotationUnchanged = "unchanged"
+
+// previousAttestation holds the materials of a previously generated
+// provenance statement or link
+type previousAttestation struct {
+	Predicate struct {
+		Materials []common.ProvenanceMaterial `json:"materials"`
+	} `json:"predicate"`
+	Signed struct {
+		Materials map[string]common.DigestSet `json:"materials"`
+	} `json:"signed"`
+}
+
+// readPreviousMaterials reads the material digests of the previous
+// provenance keyed by the material URI without its digest
+func readPreviousMaterials(file string) (map[string]common.DigestSet, error) {
+	b, err := os.ReadFile(file)
+	if err != nil {
+		return nil, err
+	}
+	var att previousAttestation
+	if err := json.Unmarshal(b, &att); err != nil {
+		return nil, fmt.Errorf("unable to parse previous provenance %s: %w", file, err)
+	}
+	prev := map[string]common.DigestSet{}
+	for _, m := range att.Predicate.Materials {
+		prev[materialKey(m.URI)] = m.Digest
+	}
+	for uri, d := range att.Signed.Materials {
+		prev[materialKey(uri)] = d
+	}
+	return prev, nil
+}
+
+// materialKey returns the material URI without the digest so that the
+// same image is matched across runs e.g. pkg:alpine:3.16@sha256:... is
+// keyed as pkg:alpine:3.16
+func materialKey(uri string) string {
+	if i := strings.LastIndex(uri, "@sha256:"); i > 0 {
+		return uri[:i]
+	}
+	return uri
+}
+
+// diffMaterials annotates the materials with their change since the previous
+// provenance and writes a summary of the changes to w
+func diffMaterials(mat []material, prev map[string]common.DigestSet, w io.Writer) {
+	counts := map[string]int{}
+	seen := map[string]bool{}
+	for _, m := range mat {
+		key := materialKey(m.URI)
+		seen[key] = true
+		change := changeUnchanged
+		if d, ok := prev[key]; !ok {
+			change = changeAdded
+		} else if d["sha256"] != m.Digest["sha256"] {
+			change = changeChanged
+		}
+		if m.Annotations != nil {
+			m.Annotations[annotationChange] = change
+		}
+		counts[change]++
+		if change != changeUnchanged {
+			fmt.Fprintf(w, "  %-9s %s\n", change, key)
+		}
+	}
+	var removed []string
+	for key := range prev {
+		if !seen[key] {
+			removed = append(removed, key)
+		}
+	}
+	sort.Strings(removed)
+	for _, key := range removed {
+		fmt.Fprintf(w, "  %-9s %s\n", "removed", key)
+	}
+	fmt.Fprintf(w, "Materials since previous provenance: %d added, %d changed, %d unchanged, %d removed\n",
+		counts[changeAdded], counts[changeChanged], counts[changeUnchanged], len(removed))
+}
+
+// changedImageMaterials returns the materials without the images that did not
+// change since the previous provenance, which is added as a material so that
+// the unchanged images are referenced by its digest.
+func changedImageMaterials(mat []material, file string) ([]material, error) {
+	b, err := os.ReadFile(file)
+	if err != nil {
+		return nil, err
+	}
+	var changed []material
+	unchanged := 0
+	for _, m := range mat {
+		if hasRole(m, roleStep, roleService, roleClone) && m.Annotations[annotationChange] == changeUnchanged {
+			unchanged++
+			continue
+		}
+		changed = append(changed, m)
+	}
+	return append(changed, material{
+		ProvenanceMaterial: common.ProvenanceMaterial{
+			URI: "file:" + file,
+			Digest: common.DigestSet{
+				"sha256": fmt.Sprintf("%x", sha256.Sum256(b)),
+			},
+		},
+		Annotations: map[string]string{
+			annotationRole:      roleBaseline,
+			annotationUnchanged: fmt.Sprintf("%d", unchanged),
+		},
+	}), nil
+}
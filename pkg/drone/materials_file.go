@@ -0,0 +1,30 @@
+package drone
+
+import "strings"
+
+// imageMaterial is an image material of the standalone materials file
+type imageMaterial struct {
+	Image  string `json:"image"`
+	Digest string `json:"digest,omitempty"`
+	Step   string `json:"step,omitempty"`
+}
+
+// imageMaterials returns the image materials i.e. of the steps, services and
+// clone, without the pipeline source and the lockfile dependencies.
+func imageMaterials(mat []material) []imageMaterial {
+	images := []imageMaterial{}
+	for _, m := range mat {
+		if !hasRole(m, roleStep, roleService, roleClone) {
+			continue
+		}
+		im := imageMaterial{
+			Image: strings.TrimPrefix(materialKey(m.URI), "pkg:"),
+			Step:  m.Annotations[annotationStep],
+		}
+		if d := m.Digest["sha256"]; d != "" {
+			im.Digest = "sha256:" + d
+		}
+		images = append(images, im)
+	}
+	return images
+}
@@ -0,0 +1,104 @@
+package drone
+
+import (
+	"bytes"
+	"encoding/json"
+	"testing"
+
+	"github.com/in-toto/in-toto-golang/in_toto/slsa_provenance/common"
+)
+
+func TestDedupMaterialsDeterministic(t *testing.T) {
+	const (
+		alpine  = "pkg:alpine:3.16@sha256:8914eb54f968791faf6a8638949e480fef81e697984fba772b3976835194c6d4"
+		busybox = "pkg:busybox:1.35@sha256:7b3ccabffc97de872a30dfd234fd972a66d247c8cfc69b0550f276481852627c"
+	)
+	mat := func(uri, role string) material {
+		return material{
+			ProvenanceMaterial: common.ProvenanceMaterial{URI: uri},
+			Annotations:        map[string]string{annotationRole: role},
+		}
+	}
+	// the same steps in another order, alpine being the image of two steps
+	first, err := json.Marshal(dedupMaterials([]material{
+		mat(alpine, roleStep), mat(busybox, roleStep), mat(alpine, roleStep),
+	}))
+	if err != nil {
+		t.Fatal(err)
+	}
+	second, err := json.Marshal(dedupMaterials([]material{
+		mat(alpine, roleStep), mat(alpine, roleStep), mat(busybox, roleStep),
+	}))
+	if err != nil {
+		t.Fatal(err)
+	}
+	third, err := json.Marshal(dedupMaterials([]material{
+		mat(busybox, roleStep), mat(alpine, roleStep), mat(alpine, roleStep),
+	}))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Equal(first, second) || !bytes.Equal(first, third) {
+		t.Errorf("materials differ with the step order\n%s\n%s\n%s", first, second, third)
+	}
+
+	var got []material
+	if err := json.Unmarshal(first, &got); err != nil {
+		t.Fatal(err)
+	}
+	if len(got) != 2 || got[0].URI != alpine || got[1].URI != busybox {
+		t.Errorf("expecting the alpine and busybox materials sorted by URI, got %s", first)
+	}
+}
+
+func TestDedupMaterialsServiceAndStep(t *testing.T) {
+	const redis = "pkg:redis:7@sha256:7b3ccabffc97de872a30dfd234fd972a66d247c8cfc69b0550f276481852627c"
+	mat := dedupMaterials([]material{
+		{
+			ProvenanceMaterial: common.ProvenanceMaterial{URI: redis},
+			Annotations:        map[string]string{annotationRole: roleService, annotationStep: "cache"},
+		},
+		{
+			ProvenanceMaterial: common.ProvenanceMaterial{URI: redis},
+			Annotations:        map[string]string{annotationRole: roleStep, annotationStep: "ping"},
+		},
+	})
+	if len(mat) != 1 {
+		t.Fatalf("expecting the redis material once, got %v", mat)
+	}
+	if got, want := mat[0].Annotations[annotationRole], roleService+","+roleStep; got != want {
+		t.Errorf("role %q, want %q", got, want)
+	}
+	if !hasRole(mat[0], roleStep) || !hasRole(mat[0], roleService) || hasRole(mat[0], roleClone) {
+		t.Errorf("expecting the roles service and step, got %q", mat[0].Annotations[annotationRole])
+	}
+	if images := imageMaterials(mat); len(images) != 1 || images[0].Step != "cache,ping" {
+		t.Errorf("expecting the redis image of the cache and ping steps, got %v", images)
+	}
+}
+
+func TestMaterialURISchemeServiceAndStep(t *testing.T) {
+	const digest = "7b3ccabffc97de872a30dfd234fd972a66d247c8cfc69b0550f276481852627c"
+	mat := dedupMaterials([]material{
+		{
+			ProvenanceMaterial: common.ProvenanceMaterial{URI: "pkg:redis:7@sha256:" + digest, Digest: common.DigestSet{"sha256": digest}},
+			Annotations:        map[string]string{annotationRole: roleService},
+		},
+		{
+			ProvenanceMaterial: common.ProvenanceMaterial{URI: "pkg:redis:7@sha256:" + digest, Digest: common.DigestSet{"sha256": digest}},
+			Annotations:        map[string]string{annotationRole: roleStep},
+		},
+	})
+	tests := []struct {
+		scheme, want string
+	}{
+		{materialSchemeOCI, "index.docker.io/library/redis@sha256:" + digest},
+		{materialSchemePURL, "pkg:docker/library/redis@sha256:" + digest},
+	}
+	for _, tt := range tests {
+		got := withMaterialURIScheme(mat, tt.scheme)
+		if len(got) != 1 || got[0].URI != tt.want {
+			t.Errorf("%s materials %v, want the URI %s", tt.scheme, got, tt.want)
+		}
+	}
+}
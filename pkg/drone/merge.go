@@ -0,0 +1,174 @@
+package drone
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+	"path"
+	"sort"
+	"strings"
+
+	intoto "github.com/in-toto/in-toto-golang/in_toto"
+	slsa "github.com/in-toto/in-toto-golang/in_toto/slsa_provenance/v0.2"
+	"github.com/urfave/cli/v2"
+)
+
+// mergeInput is a provenance statement to merge, the predicate fields
+// other than the materials and the build config are kept as is
+type mergeInput struct {
+	intoto.StatementHeader
+	Predicate map[string]json.RawMessage `json:"predicate"`
+}
+
+// MergeCommand exports the merge command.
+var MergeCommand = &cli.Command{
+	Name:      "merge",
+	Usage:     "merge the provenance of several stage runs into one statement",
+	ArgsUsage: "path/to/stage.provenance.json...",
+	Flags: []cli.Flag{
+		&cli.StringFlag{
+			Name:    "output",
+			Aliases: []string{"o"},
+			Usage:   "file to write the merged provenance to, '-' to write it to stdout",
+			Value:   stdoutPath,
+		},
+	},
+	Action: merge,
+}
+
+func merge(cliContext *cli.Context) error {
+	files := cliContext.Args().Slice()
+	if len(files) < 2 {
+		return fmt.Errorf("expecting at least two provenance files to merge")
+	}
+	var inputs []mergeInput
+	stages := make([]string, 0, len(files))
+	for _, f := range files {
+		in, err := readMergeInput(f)
+		if err != nil {
+			return err
+		}
+		if len(inputs) > 0 && (in.Type != inputs[0].Type || in.PredicateType != inputs[0].PredicateType) {
+			return fmt.Errorf("provenance %s is a %s statement of predicate %s, expecting a %s statement of predicate %s like %s",
+				f, in.Type, in.PredicateType, inputs[0].Type, inputs[0].PredicateType, files[0])
+		}
+		stage := stageOfProvenance(f)
+		for _, s := range stages {
+			if s == stage {
+				return fmt.Errorf("provenance %s and another file are both named after stage '%s'", f, stage)
+			}
+		}
+		inputs = append(inputs, in)
+		stages = append(stages, stage)
+	}
+	statement, err := mergeStatements(inputs, stages)
+	if err != nil {
+		return err
+	}
+	output := cliContext.String("output")
+	if output == stdoutPath {
+		enc := json.NewEncoder(os.Stdout)
+		enc.SetIndent("", "  ")
+		return enc.Encode(statement)
+	}
+	if err := writeJSON(output, statement); err != nil {
+		return fmt.Errorf("unable to write the merged provenance: %w", err)
+	}
+	log.Infof("Merged provenance of %d stages written to %s", len(inputs), output)
+	return nil
+}
+
+// readMergeInput reads the provenance statement, only the SLSA provenance
+// statements written by exec can be merged
+func readMergeInput(file string) (mergeInput, error) {
+	var in mergeInput
+	b, err := os.ReadFile(file)
+	if err != nil {
+		return in, err
+	}
+	if err := json.Unmarshal(b, &in); err != nil {
+		return in, fmt.Errorf("unable to parse provenance %s: %w", file, err)
+	}
+	if err := validateStatementType(in.Type); err != nil {
+		return in, fmt.Errorf("provenance %s: %w", file, err)
+	}
+	if in.PredicateType != slsa.PredicateSLSAProvenance {
+		return in, fmt.Errorf("provenance %s has predicate %s, only %s can be merged", file, in.PredicateType, slsa.PredicateSLSAProvenance)
+	}
+	return in, nil
+}
+
+// stageOfProvenance returns the stage the provenance file is named after
+// e.g. build for out/build.provenance.json or .drone.yml for .drone.yml-provenance.json
+func stageOfProvenance(file string) string {
+	base := path.Base(file)
+	for _, suffix := range []string{".provenance.json", "-provenance.json", ".json"} {
+		if strings.HasSuffix(base, suffix) && base != suffix {
+			return strings.TrimSuffix(base, suffix)
+		}
+	}
+	return base
+}
+
+// mergeStatements merges the subjects and the materials of the statements,
+// removing the duplicates, and keys their build configs by stage. The other
+// predicate fields e.g. the builder are those of the first statement.
+func mergeStatements(inputs []mergeInput, stages []string) (*intoto.Statement, error) {
+	predicate := map[string]interface{}{}
+	for k, v := range inputs[0].Predicate {
+		predicate[k] = v
+	}
+	var subjects []intoto.Subject
+	var mat []material
+	buildConfigs := map[string]json.RawMessage{}
+	for i, in := range inputs {
+		subjects = append(subjects, in.Subject...)
+		if raw, ok := in.Predicate["materials"]; ok {
+			var m []material
+			if err := json.Unmarshal(raw, &m); err != nil {
+				return nil, fmt.Errorf("unable to parse the materials of stage '%s': %w", stages[i], err)
+			}
+			mat = append(mat, m...)
+		}
+		if raw, ok := in.Predicate["buildConfig"]; ok {
+			buildConfigs[stages[i]] = raw
+		}
+	}
+	// the annotations of the duplicated materials are merged
+	for i := range mat {
+		if mat[i].Annotations == nil {
+			mat[i].Annotations = map[string]string{}
+		}
+	}
+	predicate["materials"] = dedupMaterials(mat)
+	predicate["buildConfig"] = map[string]interface{}{
+		"stages": buildConfigs,
+	}
+	return &intoto.Statement{
+		StatementHeader: intoto.StatementHeader{
+			Type:          inputs[0].Type,
+			PredicateType: inputs[0].PredicateType,
+			Subject:       dedupSubjects(subjects),
+		},
+		Predicate: predicate,
+	}, nil
+}
+
+// dedupSubjects removes the subjects with same name and digest and sorts
+// them by name
+func dedupSubjects(subjects []intoto.Subject) []intoto.Subject {
+	seen := map[string]bool{}
+	deduped := make([]intoto.Subject, 0, len(subjects))
+	for _, s := range subjects {
+		key := s.Name + "@" + s.Digest["sha256"]
+		if seen[key] {
+			continue
+		}
+		seen[key] = true
+		deduped = append(deduped, s)
+	}
+	sort.SliceStable(deduped, func(i, j int) bool {
+		return deduped[i].Name < deduped[j].Name
+	})
+	return deduped
+}
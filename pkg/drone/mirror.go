@@ -0,0 +1,76 @@
+package drone
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"net/url"
+	"strings"
+
+	"github.com/drone-runners/drone-runner-docker/engine"
+	"github.com/drone/runner-go/pipeline/runtime"
+	"github.com/google/go-containerregistry/pkg/name"
+)
+
+// parseRegistryMirror validates the registry mirror that is defined either as
+// an URL e.g. https://mirror.gcr.io or as a host with an optional path,
+// and returns it without the scheme.
+func parseRegistryMirror(mirror string) (string, error) {
+	if mirror == "" {
+		return "", nil
+	}
+	if !strings.Contains(mirror, "://") {
+		mirror = "https://" + mirror
+	}
+	u, err := url.Parse(mirror)
+	if err != nil {
+		return "", fmt.Errorf("invalid registry mirror '%s' : %w", mirror, err)
+	}
+	if (u.Scheme != "http" && u.Scheme != "https") || u.Host == "" || u.RawQuery != "" || u.Fragment != "" {
+		return "", fmt.Errorf("invalid registry mirror '%s', expecting [https://]host[:port][/path]", mirror)
+	}
+	if _, err := name.NewRegistry(u.Host); err != nil {
+		return "", fmt.Errorf("invalid registry mirror '%s' : %w", mirror, err)
+	}
+	return u.Host + strings.TrimSuffix(u.Path, "/"), nil
+}
+
+// mirrorImage rewrites the Docker Hub image to be pulled from the mirror,
+// the images of the other registries are returned as is.
+func mirrorImage(image, mirror string) string {
+	if mirror == "" {
+		return image
+	}
+	ref, err := name.ParseReference(image)
+	if err != nil || ref.Context().RegistryStr() != name.DefaultRegistry {
+		return image
+	}
+	sep := ":"
+	if _, ok := ref.(name.Digest); ok {
+		sep = "@"
+	}
+	return mirror + "/" + ref.Context().RepositoryStr() + sep + ref.Identifier()
+}
+
+// mirrorEngine is a pipeline engine that pulls the Docker Hub step images
+// from a registry mirror, the pipeline keeps the original step images.
+type mirrorEngine struct {
+	runtime.Engine
+	mirror string
+}
+
+var _ runtime.Engine = (*mirrorEngine)(nil)
+
+// Run implements runtime.Engine
+func (m *mirrorEngine) Run(ctx context.Context, spec runtime.Spec, stepv runtime.Step, output io.Writer) (*runtime.State, error) {
+	step, ok := stepv.(*engine.Step)
+	if !ok {
+		return m.Engine.Run(ctx, spec, stepv, output)
+	}
+	mirrored := *step
+	mirrored.Image = mirrorImage(step.Image, m.mirror)
+	if mirrored.Image != step.Image {
+		log.Debugf("Step %s, pulling image %s from mirror as %s", step.Name, step.Image, mirrored.Image)
+	}
+	return m.Engine.Run(ctx, spec, &mirrored, output)
+}
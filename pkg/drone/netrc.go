@@ -0,0 +1,89 @@
+package drone
+
+import (
+	"fmt"
+	"os"
+	"strings"
+
+	"github.com/drone/drone-go/drone"
+)
+
+// netrcCredentials returns the netrc credentials of the clone, the flags
+// take precedence over the entry of the machine read from the netrc file.
+func netrcCredentials(file, machine, login, password string) (*drone.Netrc, error) {
+	netrc := &drone.Netrc{
+		Machine:  machine,
+		Login:    login,
+		Password: password,
+	}
+	if file == "" {
+		return netrc, nil
+	}
+	entry, err := readNetrc(file, machine)
+	if err != nil {
+		return nil, err
+	}
+	if netrc.Machine == "" {
+		netrc.Machine = entry.Machine
+	}
+	if netrc.Login == "" {
+		netrc.Login = entry.Login
+	}
+	if netrc.Password == "" {
+		netrc.Password = entry.Password
+	}
+	return netrc, nil
+}
+
+// readNetrc reads the entry of the machine from the netrc file, without a
+// machine the file must have a single machine entry.
+func readNetrc(file, machine string) (*drone.Netrc, error) {
+	b, err := os.ReadFile(file)
+	if err != nil {
+		return nil, fmt.Errorf("unable to read --netrc-file: %w", err)
+	}
+	var entries []*drone.Netrc
+	var def *drone.Netrc
+	var entry *drone.Netrc
+	tokens := strings.Fields(string(b))
+	for i := 0; i < len(tokens); i++ {
+		next := ""
+		if i+1 < len(tokens) {
+			next = tokens[i+1]
+		}
+		switch tokens[i] {
+		case "machine":
+			entry = &drone.Netrc{Machine: next}
+			entries = append(entries, entry)
+			i++
+		case "default":
+			def = &drone.Netrc{}
+			entry = def
+		case "login":
+			if entry != nil {
+				entry.Login = next
+			}
+			i++
+		case "password":
+			if entry != nil {
+				entry.Password = next
+			}
+			i++
+		case "account":
+			i++
+		}
+	}
+	for _, e := range entries {
+		if machine == "" && len(entries) == 1 || e.Machine == machine {
+			return e, nil
+		}
+	}
+	if def != nil && (machine != "" || len(entries) == 0) {
+		def.Machine = machine
+		return def, nil
+	}
+	if machine == "" {
+		return nil, fmt.Errorf("--netrc-file %s has %d machine entries, select one with --netrc-machine", file, len(entries))
+	}
+	return nil, fmt.Errorf("machine %s not found in --netrc-file %s", machine, file)
+}
@@ -0,0 +1,187 @@
+package drone
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"os"
+	"path"
+	"regexp"
+	"strings"
+	"text/template"
+	"time"
+
+	"github.com/kameshsampath/drone-provenance/pkg/utils"
+	"github.com/urfave/cli/v2"
+)
+
+// unsafeNameChars matches the characters that are not safe to use in file names
+var unsafeNameChars = regexp.MustCompile(`[^A-Za-z0-9._-]+`)
+
+// stdoutPath is the output path to write the output to stdout
+const stdoutPath = "-"
+
+// outputPaths holds the paths of the files written by a run,
+// an empty path means the output is not written
+type outputPaths struct {
+	Provenance string
+	Log        string
+	Summary    string
+}
+
+// newOutputPaths computes the output file paths for the stage. When the output
+// directory is set all the outputs are written under it, named after the stage,
+// otherwise the provenance is written next to the pipeline file and the step
+// logs under the drone-ci logs directory, named after the stage and the run time. The
+// provenance path is overridden by --provenance-file or --provenance-stdout,
+// and its file name by --provenance-name-template.
+func newOutputPaths(commy *execCommand, stage string) (*outputPaths, error) {
+	out, err := stageOutputPaths(commy, stage)
+	if err != nil {
+		return nil, err
+	}
+	switch {
+	case commy.ProvenanceStdout:
+		out.Provenance = stdoutPath
+	case commy.ProvenanceFile != "":
+		out.Provenance = commy.ProvenanceFile
+	case commy.ProvenanceName != nil:
+		fn, err := provenanceName(commy.ProvenanceName, newProvenanceNameData(commy, stage))
+		if err != nil {
+			return nil, err
+		}
+		dir := "."
+		if out.Provenance != "" {
+			dir = path.Dir(out.Provenance)
+		}
+		out.Provenance = path.Join(dir, fn)
+	case out.Provenance == "":
+		return nil, fmt.Errorf("reading the pipeline from stdin requires --provenance-file, --provenance-stdout or --output-dir")
+	}
+	return out, nil
+}
+
+func stageOutputPaths(commy *execCommand, stage string) (*outputPaths, error) {
+	name := unsafeNameChars.ReplaceAllString(stage, "-")
+	if commy.OutputDir == "" {
+		logFile := path.Join(droneCILogsDir, name+"-"+nameTime{time.Now().UTC()}.String()+".log")
+		if commy.Source == stdinSource {
+			return &outputPaths{Log: logFile}, nil
+		}
+		pf := commy.Source
+		return &outputPaths{
+			Provenance: path.Join(path.Dir(pf), path.Base(pf)+"-provenance.json"),
+			Log:        logFile,
+		}, nil
+	}
+	if err := os.MkdirAll(commy.OutputDir, 0o755); err != nil {
+		return nil, fmt.Errorf("unable to create output directory %s: %w", commy.OutputDir, err)
+	}
+	return &outputPaths{
+		Provenance: path.Join(commy.OutputDir, name+".provenance.json"),
+		Log:        path.Join(commy.OutputDir, name+".log"),
+		Summary:    path.Join(commy.OutputDir, name+".summary.json"),
+	}, nil
+}
+
+// writeJSON writes v as indented json to the file
+func writeJSON(file string, v interface{}) error {
+	f, err := os.Create(file)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+	enc := json.NewEncoder(f)
+	enc.SetIndent("", "  ")
+	return enc.Encode(v)
+}
+
+// supported output formats of the commands
+const (
+	outputText = "text"
+	outputJSON = "json"
+)
+
+// outputFlag is the flag to choose the output format of a command
+func outputFlag() cli.Flag {
+	return &cli.StringFlag{
+		Name:  "output",
+		Usage: "output format, one of text or json",
+		Value: outputText,
+	}
+}
+
+// validateOutput checks if the output format is one of the supported formats
+func validateOutput(output string) error {
+	switch output {
+	case outputText, outputJSON:
+		return nil
+	}
+	return fmt.Errorf("unsupported output format '%s'", output)
+}
+
+// maxNameLen is the maximum length of a file name on most file systems
+const maxNameLen = 255
+
+// provenanceNameData holds the variables of the provenance file name template
+type provenanceNameData struct {
+	Stage     string
+	BuildID   int64
+	Timestamp nameTime
+	Source    string
+}
+
+// nameTime is the time of the provenance file name template, printed as
+// 20060102150405 and formatted with e.g. {{.Timestamp.Format "20060102"}}
+type nameTime struct {
+	time.Time
+}
+
+func (t nameTime) String() string {
+	return t.Format("20060102150405")
+}
+
+func newProvenanceNameData(commy *execCommand, stage string) provenanceNameData {
+	source := stdinSource
+	if commy.Source != stdinSource {
+		source = path.Base(commy.Source)
+	}
+	return provenanceNameData{
+		Stage:     stage,
+		BuildID:   commy.Build.ID,
+		Timestamp: nameTime{time.Now().UTC()},
+		Source:    source,
+	}
+}
+
+// parseProvenanceNameTemplate parses the provenance file name template and
+// checks it only uses the known variables
+func parseProvenanceNameTemplate(text string) (*template.Template, error) {
+	t, err := template.New("provenance-name").Parse(text)
+	if err != nil {
+		return nil, fmt.Errorf("invalid --provenance-name-template: %w", err)
+	}
+	if err := t.Execute(io.Discard, provenanceNameData{}); err != nil {
+		return nil, fmt.Errorf("invalid --provenance-name-template: %w", err)
+	}
+	return t, nil
+}
+
+// provenanceName renders the provenance file name with the characters that
+// are not safe in file names replaced. A name that is empty or too long once
+// sanitized is replaced, or shortened, with the hash of the rendered name.
+func provenanceName(t *template.Template, data provenanceNameData) (string, error) {
+	var b strings.Builder
+	if err := t.Execute(&b, data); err != nil {
+		return "", fmt.Errorf("unable to render --provenance-name-template: %w", err)
+	}
+	name := unsafeNameChars.ReplaceAllString(b.String(), "-")
+	hash := utils.HashOfString(b.String())
+	switch {
+	case strings.Trim(name, ".-") == "":
+		return hash + ".provenance.json", nil
+	case len(name) > maxNameLen:
+		return name[:maxNameLen-len(hash)-1] + "-" + hash, nil
+	}
+	return name, nil
+}
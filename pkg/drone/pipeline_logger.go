@@ -2,11 +2,8 @@ package drone
 
 import (
 	"context"
-	"fmt"
 	"io"
-	"path"
 
-	"github.com/bfontaine/jsons"
 	"github.com/drone/drone-go/drone"
 	"github.com/drone/runner-go/pipeline"
 )
@@ -15,15 +12,15 @@ type jSONFileStreamer struct {
 	seq     *sequence
 	col     *sequence
 	logFile string
-	writer  *jsons.FileWriter
+	writer  *logFileWriter
+	plain   bool
 }
 
 var _ pipeline.Streamer = (*jSONFileStreamer)(nil)
 
-func newStreamer(pipelineID string) (*jSONFileStreamer, error) {
-	logFile := path.Join(droneCILogsDir, fmt.Sprintf("%s.log", pipelineID))
-	fw := jsons.NewFileWriter(logFile)
-	if err := fw.Open(); err != nil {
+func newStreamer(logFile string, maxSize int64, format string) (*jSONFileStreamer, error) {
+	fw, err := newLogFileWriter(logFile, maxSize)
+	if err != nil {
 		return nil, err
 	}
 	return &jSONFileStreamer{
@@ -31,6 +28,7 @@ func newStreamer(pipelineID string) (*jSONFileStreamer, error) {
 		col:     new(sequence),
 		logFile: logFile,
 		writer:  fw,
+		plain:   format == logFormatPlain,
 	}, nil
 }
 
@@ -48,5 +46,49 @@ func (j *jSONFileStreamer) Stream(_ context.Context, state *pipeline.State, name
 		seq:    j.seq,
 		name:   c.Name,
 		number: c.Number,
+		plain:  j.plain,
 	}
 }
+
+// Close closes the underlying log file
+func (j *jSONFileStreamer) Close() error {
+	return j.writer.Close()
+}
+
+// teeStreamer streams the step logs to all of its streamers
+type teeStreamer []pipeline.Streamer
+
+var _ pipeline.Streamer = (teeStreamer)(nil)
+
+// Stream implements pipeline.Streamer
+func (t teeStreamer) Stream(ctx context.Context, state *pipeline.State, name string) io.WriteCloser {
+	wcs := make(multiWriteCloser, 0, len(t))
+	for _, s := range t {
+		wcs = append(wcs, s.Stream(ctx, state, name))
+	}
+	return wcs
+}
+
+// multiWriteCloser writes and closes all of its writers
+type multiWriteCloser []io.WriteCloser
+
+// Write implements io.WriteCloser
+func (m multiWriteCloser) Write(b []byte) (int, error) {
+	for _, w := range m {
+		if _, err := w.Write(b); err != nil {
+			return len(b), err
+		}
+	}
+	return len(b), nil
+}
+
+// Close implements io.WriteCloser
+func (m multiWriteCloser) Close() error {
+	var result error
+	for _, w := range m {
+		if err := w.Close(); err != nil && result == nil {
+			result = err
+		}
+	}
+	return result
+}
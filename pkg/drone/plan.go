@@ -0,0 +1,31 @@
+package drone
+
+import (
+	"fmt"
+	"io"
+	"strings"
+	"text/tabwriter"
+
+	"github.com/drone-runners/drone-runner-docker/engine"
+	"github.com/drone/runner-go/pipeline/runtime"
+)
+
+// writePlan writes the steps that will run, in order, with their image,
+// run policy and dependencies as a table.
+func writePlan(w io.Writer, spec *engine.Spec) error {
+	tw := tabwriter.NewWriter(w, 0, 4, 2, ' ', 0)
+	fmt.Fprintln(tw, "#\tSTEP\tROLE\tIMAGE\tRUN POLICY\tDEPENDS ON")
+	n := 0
+	for _, s := range spec.Steps {
+		if s.RunPolicy == runtime.RunNever {
+			continue
+		}
+		n++
+		fmt.Fprintf(tw, "%d\t%s\t%s\t%s\t%s\t%s\n", n, s.Name, stepRole(s), s.Image, s.RunPolicy, strings.Join(s.DependsOn, ","))
+	}
+	if err := tw.Flush(); err != nil {
+		return err
+	}
+	_, err := fmt.Fprintf(w, "%d of %d steps will run\n", n, len(spec.Steps))
+	return err
+}
@@ -0,0 +1,45 @@
+package drone
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+)
+
+// predicateExtensionsField is the predicate field the extra fields are added
+// under, so that they can never clash with the SLSA predicate fields
+const predicateExtensionsField = "extensions"
+
+// readPredicateExtra reads the json object of org specific fields, e.g. ticket
+// ids or approval records, to add to the provenance predicate.
+func readPredicateExtra(file string) (map[string]json.RawMessage, error) {
+	b, err := os.ReadFile(file)
+	if err != nil {
+		return nil, fmt.Errorf("unable to read predicate extra file: %w", err)
+	}
+	var extra map[string]json.RawMessage
+	if err := json.Unmarshal(b, &extra); err != nil {
+		return nil, fmt.Errorf("predicate extra file %s is not a json object: %w", file, err)
+	}
+	return extra, nil
+}
+
+// mergePredicateExtra returns the predicate with the extra fields added under
+// its extensions field
+func mergePredicateExtra(predicate interface{}, extra map[string]json.RawMessage) (map[string]json.RawMessage, error) {
+	b, err := json.Marshal(predicate)
+	if err != nil {
+		return nil, err
+	}
+	merged := map[string]json.RawMessage{}
+	if err := json.Unmarshal(b, &merged); err != nil {
+		return nil, err
+	}
+	if _, ok := merged[predicateExtensionsField]; ok {
+		return nil, fmt.Errorf("predicate field '%s' is already set", predicateExtensionsField)
+	}
+	if merged[predicateExtensionsField], err = json.Marshal(extra); err != nil {
+		return nil, err
+	}
+	return merged, nil
+}
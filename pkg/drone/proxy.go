@@ -0,0 +1,48 @@
+package drone
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"net/url"
+
+	"github.com/google/go-containerregistry/pkg/crane"
+	"golang.org/x/net/http/httpproxy"
+)
+
+// registryTransport is the transport of the registry calls e.g. resolving the
+// image digests, nil is the default transport using the proxy environment variables.
+var registryTransport http.RoundTripper
+
+// newProxyTransport returns a transport using the proxies, independently of
+// the HTTP_PROXY, HTTPS_PROXY and NO_PROXY environment variables.
+func newProxyTransport(httpProxy, httpsProxy, noProxy string) (http.RoundTripper, error) {
+	for flag, p := range map[string]string{"--http-proxy": httpProxy, "--https-proxy": httpsProxy} {
+		if p == "" {
+			continue
+		}
+		if u, err := url.Parse(p); err != nil || u.Host == "" {
+			return nil, fmt.Errorf("invalid %s '%s', expecting an URL e.g. http://proxy:3128", flag, p)
+		}
+	}
+	proxy := (&httpproxy.Config{
+		HTTPProxy:  httpProxy,
+		HTTPSProxy: httpsProxy,
+		NoProxy:    noProxy,
+	}).ProxyFunc()
+	t := http.DefaultTransport.(*http.Transport).Clone()
+	t.Proxy = func(req *http.Request) (*url.URL, error) {
+		return proxy(req.URL)
+	}
+	return t, nil
+}
+
+// craneOptions returns the options of the registry calls with the context
+// and, when set, the registry transport.
+func craneOptions(ctx context.Context, opts ...crane.Option) []crane.Option {
+	opts = append(opts, crane.WithContext(ctx))
+	if registryTransport != nil {
+		opts = append(opts, crane.WithTransport(registryTransport))
+	}
+	return opts
+}
@@ -0,0 +1,176 @@
+package drone
+
+import (
+	"fmt"
+	"os"
+	"path"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/urfave/cli/v2"
+)
+
+// the defaults of the prune command and of the --auto-prune of the runs
+const (
+	defaultPruneOlderThan = "7d"
+	defaultPruneKeepLast  = 10
+)
+
+// PruneCommand exports the prune command.
+var PruneCommand = &cli.Command{
+	Name:  "prune",
+	Usage: "remove the provenance, logs and summaries of the old runs of the run history, and the old cached compiled pipelines",
+	Flags: []cli.Flag{
+		&cli.StringFlag{
+			Name:  "older-than",
+			Usage: "age of the removed runs e.g. 7d or 12h",
+			Value: defaultPruneOlderThan,
+		},
+		&cli.IntFlag{
+			Name:  "keep-last",
+			Usage: "number of the most recent runs that are never removed",
+			Value: defaultPruneKeepLast,
+		},
+	},
+	Action: prune,
+}
+
+func prune(cliContext *cli.Context) error {
+	age, err := parseAge(cliContext.String("older-than"))
+	if err != nil {
+		return err
+	}
+	keepLast := cliContext.Int("keep-last")
+	if keepLast < 0 {
+		return fmt.Errorf("invalid --keep-last %d, expecting a positive number", keepLast)
+	}
+	removed, err := pruneRuns(time.Now().Add(-age), keepLast)
+	for _, f := range removed {
+		fmt.Println(f)
+	}
+	log.Infof("Removed %d file(s) older than %s", len(removed), cliContext.String("older-than"))
+	return err
+}
+
+// parseAge parses the age in days e.g. 7d, or as a duration e.g. 12h
+func parseAge(age string) (time.Duration, error) {
+	if days := strings.TrimSuffix(age, "d"); days != age {
+		n, err := strconv.Atoi(days)
+		if err != nil || n < 0 {
+			return 0, fmt.Errorf("invalid age '%s', expecting e.g. 7d or 12h", age)
+		}
+		return time.Duration(n) * 24 * time.Hour, nil
+	}
+	d, err := time.ParseDuration(age)
+	if err != nil || d < 0 {
+		return 0, fmt.Errorf("invalid age '%s', expecting e.g. 7d or 12h", age)
+	}
+	return d, nil
+}
+
+// pruneRuns removes the runs of the history older than the cutoff, except the
+// keepLast most recent runs, with their files and the cached compiled pipelines
+// older than the cutoff. The files of the removed runs are only removed when
+// they were not written since the cutoff and are not the files of a kept run,
+// as the runs of the same pipeline write the same files. It returns the removed
+// files.
+func pruneRuns(cutoff time.Time, keepLast int) ([]string, error) {
+	history, err := readRunHistory()
+	if err != nil {
+		return nil, err
+	}
+	var kept, pruned []runRecord
+	for i, r := range history {
+		if i < len(history)-keepLast && time.Unix(r.Timestamp, 0).Before(cutoff) {
+			pruned = append(pruned, r)
+			continue
+		}
+		kept = append(kept, r)
+	}
+	keptFiles := map[string]bool{}
+	for _, r := range kept {
+		for _, f := range runFiles(r) {
+			keptFiles[f] = true
+		}
+	}
+	var removed []string
+	for _, r := range pruned {
+		for _, f := range runFiles(r) {
+			if keptFiles[f] {
+				continue
+			}
+			if removeOlderFile(f, cutoff) {
+				removed = append(removed, f)
+			}
+		}
+	}
+	if entries, err := os.ReadDir(compileCacheDir()); err == nil {
+		for _, e := range entries {
+			f := path.Join(compileCacheDir(), e.Name())
+			if !e.IsDir() && removeOlderFile(f, cutoff) {
+				removed = append(removed, f)
+			}
+		}
+	}
+	if len(pruned) > 0 {
+		if err := writeRunHistory(kept); err != nil {
+			return removed, err
+		}
+	}
+	return removed, nil
+}
+
+// runFiles returns the candidate files of the run i.e. the provenance, with
+// its compressed and checksum variants, the log parts and the summary. Only
+// the files under the drone-ci home or the output directory of the run are
+// candidates, the others are skipped as they might not be owned by the run.
+func runFiles(r runRecord) []string {
+	var files []string
+	for _, f := range recordedFiles(r) {
+		if !underDir(f, droneCIHome) && !underDir(f, r.OutputDir) {
+			log.Warnf("Skipping %s, it is neither under %s nor under the output directory of the run", f, droneCIHome)
+			continue
+		}
+		files = append(files, f)
+	}
+	return files
+}
+
+// recordedFiles returns the files recorded for the run
+func recordedFiles(r runRecord) []string {
+	var files []string
+	if r.Provenance != "" && r.Provenance != stdoutPath {
+		files = append(files, r.Provenance, r.Provenance+".sha256", r.Provenance+".gz", r.Provenance+".gz.sha256")
+	}
+	if r.Log != "" {
+		files = append(files, logFileParts(r.Log)...)
+	}
+	if r.Summary != "" {
+		files = append(files, r.Summary)
+	}
+	return files
+}
+
+// underDir returns whether the file is inside the directory
+func underDir(f, dir string) bool {
+	if dir == "" {
+		return false
+	}
+	rel, err := filepath.Rel(absRunPath(dir), absRunPath(f))
+	return err == nil && rel != "." && rel != ".." && !strings.HasPrefix(rel, ".."+string(filepath.Separator))
+}
+
+// removeOlderFile removes the file when it was last modified before the cutoff
+func removeOlderFile(f string, cutoff time.Time) bool {
+	fi, err := os.Stat(f)
+	if err != nil || fi.IsDir() || !fi.ModTime().Before(cutoff) {
+		return false
+	}
+	if err := os.Remove(f); err != nil {
+		log.Warnf("Unable to remove %s,%v", f, err)
+		return false
+	}
+	return true
+}
@@ -0,0 +1,139 @@
+package drone
+
+import (
+	"context"
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/drone/drone-go/drone"
+	"github.com/drone/runner-go/pipeline"
+)
+
+// stepRecord is a step as reported by the state transitions of the run
+type stepRecord struct {
+	Name     string
+	Number   int
+	Status   string
+	ExitCode int
+	Error    string
+	Started  time.Time
+	Stopped  time.Time
+}
+
+// Duration returns the wall-clock duration of the step, zero if it did not complete
+func (r stepRecord) Duration() time.Duration {
+	if r.Started.IsZero() || r.Stopped.IsZero() {
+		return 0
+	}
+	return r.Stopped.Sub(r.Started)
+}
+
+// stateRecorder is a pipeline reporter that records the state transitions of the
+// stage and its steps, with their time, as they happen. It is the source of the
+// step statuses and durations once the run has completed.
+type stateRecorder struct {
+	sync.Mutex
+	status  string
+	started time.Time
+	stopped time.Time
+	steps   map[string]*stepRecord
+}
+
+var _ pipeline.Reporter = (*stateRecorder)(nil)
+
+// newStateRecorder returns a recorder of the run starting now, the execer
+// only reports the stage once it has completed.
+func newStateRecorder() *stateRecorder {
+	return &stateRecorder{
+		started: time.Now(),
+		steps:   map[string]*stepRecord{},
+	}
+}
+
+// ReportStage implements pipeline.Reporter
+func (r *stateRecorder) ReportStage(_ context.Context, state *pipeline.State) error {
+	state.Lock()
+	status := state.Stage.Status
+	state.Unlock()
+
+	now := time.Now()
+	r.Lock()
+	defer r.Unlock()
+	r.status = status
+	if status != drone.StatusPending && status != drone.StatusRunning {
+		r.stopped = now
+	}
+	return nil
+}
+
+// ReportStep implements pipeline.Reporter
+func (r *stateRecorder) ReportStep(_ context.Context, state *pipeline.State, name string) error {
+	var step drone.Step
+	state.Lock()
+	for _, s := range state.Stage.Steps {
+		if s.Name == name {
+			step = *s
+			break
+		}
+	}
+	state.Unlock()
+
+	now := time.Now()
+	r.Lock()
+	defer r.Unlock()
+	rec, ok := r.steps[name]
+	if !ok {
+		rec = &stepRecord{Name: name}
+		r.steps[name] = rec
+	}
+	rec.Number = step.Number
+	rec.Status = step.Status
+	rec.ExitCode = step.ExitCode
+	rec.Error = step.Error
+	switch step.Status {
+	case drone.StatusPending:
+	case drone.StatusRunning:
+		rec.Started = now
+	default:
+		// the skipped steps never started
+		if rec.Started.IsZero() {
+			rec.Started = now
+		}
+		rec.Stopped = now
+	}
+	return nil
+}
+
+// Step returns the record of the step, false if the step was never reported
+func (r *stateRecorder) Step(name string) (stepRecord, bool) {
+	r.Lock()
+	defer r.Unlock()
+	rec, ok := r.steps[name]
+	if !ok {
+		return stepRecord{}, false
+	}
+	return *rec, true
+}
+
+// Steps returns the records of the reported steps by step number
+func (r *stateRecorder) Steps() []stepRecord {
+	r.Lock()
+	defer r.Unlock()
+	recs := make([]stepRecord, 0, len(r.steps))
+	for _, rec := range r.steps {
+		recs = append(recs, *rec)
+	}
+	sort.Slice(recs, func(i, j int) bool { return recs[i].Number < recs[j].Number })
+	return recs
+}
+
+// Duration returns the wall-clock duration of the stage, zero if it did not complete
+func (r *stateRecorder) Duration() time.Duration {
+	r.Lock()
+	defer r.Unlock()
+	if r.started.IsZero() || r.stopped.IsZero() {
+		return 0
+	}
+	return r.stopped.Sub(r.started)
+}
@@ -0,0 +1,86 @@
+package drone
+
+import (
+	"fmt"
+
+	"github.com/drone/runner-go/registry/auths"
+	"github.com/urfave/cli/v2"
+)
+
+// credentialFlags are the flags holding credentials, their values and the
+// environment variables they are read from are always redacted.
+var credentialFlags = map[string]bool{
+	"identity-token":   true,
+	"netrc-password":   true,
+	"provenance-token": true,
+}
+
+// redactedValue replaces the secret values recorded in the provenance
+const redactedValue = "******"
+
+// redactor redacts the secrets and the credentials of the run from the
+// environment recorded in the provenance and from the dumped pipeline.
+type redactor struct {
+	keys   map[string]bool
+	values map[string]bool
+}
+
+// newRedactor returns the redactor of the secrets, of the values of the
+// credential flags and of the passwords of the registry file.
+func newRedactor(input *cli.Context, secrets map[string]string, registryFile string) (*redactor, error) {
+	r := &redactor{keys: map[string]bool{}, values: map[string]bool{}}
+	for k, v := range secrets {
+		r.keys[k] = true
+		r.addValue(v)
+	}
+	if input.Command != nil {
+		for _, f := range input.Command.Flags {
+			sf, ok := f.(*cli.StringFlag)
+			if !ok || !credentialFlags[sf.Name] {
+				continue
+			}
+			for _, e := range sf.EnvVars {
+				r.keys[e] = true
+			}
+			r.addValue(input.String(sf.Name))
+		}
+	}
+	if registryFile != "" {
+		registries, err := auths.ParseFile(registryFile)
+		if err != nil {
+			return nil, fmt.Errorf("unable to read registry file %s: %w", registryFile, err)
+		}
+		for _, reg := range registries {
+			r.addValue(reg.Password)
+		}
+	}
+	return r, nil
+}
+
+// addValue redacts the non empty value
+func (r *redactor) addValue(v string) {
+	if v != "" {
+		r.values[v] = true
+	}
+}
+
+// withValues returns a copy of the redactor also redacting the values
+func (r *redactor) withValues(values ...string) *redactor {
+	c := &redactor{keys: r.keys, values: make(map[string]bool, len(r.values)+len(values))}
+	for v := range r.values {
+		c.values[v] = true
+	}
+	for _, v := range values {
+		c.addValue(v)
+	}
+	return c
+}
+
+// redact returns the value of the environment variable, redacted when the
+// variable holds a secret or a credential.
+func (r *redactor) redact(k, v string) string {
+	if r.keys[k] || r.values[v] {
+		return redactedValue
+	}
+	return v
+}
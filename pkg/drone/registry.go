@@ -0,0 +1,39 @@
+package drone
+
+import (
+	"fmt"
+
+	"github.com/drone/runner-go/registry/auths"
+	"github.com/google/go-containerregistry/pkg/name"
+)
+
+// dockerHubHosts are the equivalent host names of docker hub
+var dockerHubHosts = map[string]bool{
+	"docker.io":       true,
+	"index.docker.io": true,
+}
+
+// registryAuth returns the encoded credentials from the registry file
+// matching the registry of the image, the same credentials used to
+// pull the step images. An empty string is returned when there is no
+// registry file or no matching credentials.
+func registryAuth(registryFile, image string) (string, error) {
+	if registryFile == "" {
+		return "", nil
+	}
+	ref, err := name.ParseReference(image)
+	if err != nil {
+		return "", fmt.Errorf("invalid image '%s' : %w", image, err)
+	}
+	host := ref.Context().RegistryStr()
+	registries, err := auths.ParseFile(registryFile)
+	if err != nil {
+		return "", fmt.Errorf("unable to read registry file %s: %w", registryFile, err)
+	}
+	for _, r := range registries {
+		if r.Address == host || (dockerHubHosts[r.Address] && dockerHubHosts[host]) {
+			return auths.Header(r.Username, r.Password), nil
+		}
+	}
+	return "", nil
+}
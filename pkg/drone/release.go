@@ -0,0 +1,60 @@
+package drone
+
+import (
+	"context"
+	"fmt"
+	"strconv"
+	"strings"
+)
+
+// provenanceRelease is the release section of the provenance predicate, the
+// tag of the release build and whether the build is a release
+type provenanceRelease struct {
+	Tag     string `json:"tag,omitempty"`
+	Release bool   `json:"release"`
+}
+
+// releaseTag returns the tag of the release, --release-tag takes precedence
+// over the tag of the build and the tag of the commit detected from the git
+// work tree in the current directory.
+func releaseTag(ctx context.Context, tag, ref string) (string, error) {
+	if tag != "" {
+		return tag, nil
+	}
+	if strings.HasPrefix(ref, "refs/tags/") {
+		return strings.TrimPrefix(ref, "refs/tags/"), nil
+	}
+	lines, err := git(ctx, "describe", "--tags", "--exact-match", "HEAD")
+	if err != nil || len(lines) == 0 {
+		return "", fmt.Errorf("unable to detect the release tag, the commit is not tagged, set it with --release-tag")
+	}
+	log.Infof("Detected the release tag %s", lines[0])
+	return lines[0], nil
+}
+
+// newProvenanceRelease returns the release section of the provenance, nil
+// when the build is neither a release nor has a release tag
+func newProvenanceRelease(commy *execCommand) *provenanceRelease {
+	if !commy.Release && commy.ReleaseTag == "" {
+		return nil
+	}
+	return &provenanceRelease{
+		Tag:     commy.ReleaseTag,
+		Release: commy.Release,
+	}
+}
+
+// withReleaseParameters adds the release to the invocation parameters
+func withReleaseParameters(params map[string]string, release *provenanceRelease) map[string]string {
+	if release == nil {
+		return params
+	}
+	if params == nil {
+		params = map[string]string{}
+	}
+	if release.Tag != "" {
+		params["release.tag"] = release.Tag
+	}
+	params["release"] = strconv.FormatBool(release.Release)
+	return params
+}
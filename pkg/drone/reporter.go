@@ -0,0 +1,90 @@
+package drone
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"os"
+	"sync"
+
+	"github.com/drone/drone-go/drone"
+	"github.com/drone/runner-go/pipeline"
+	"github.com/kameshsampath/drone-provenance/pkg/utils"
+)
+
+// progressReporter prints a line for every step transition
+// e.g. "[2/5] running build"
+type progressReporter struct {
+	sync.Mutex
+	out     io.Writer
+	tty     bool
+	started int
+}
+
+var _ pipeline.Reporter = (*progressReporter)(nil)
+
+// newProgressReporter returns the reporter printing to out, the statuses are
+// colored when out is a terminal and the colors are not disabled.
+func newProgressReporter(out io.Writer, noColor bool) *progressReporter {
+	f, isFile := out.(*os.File)
+	return &progressReporter{
+		out: out,
+		tty: !noColor && isFile && utils.IsTerminal(f),
+	}
+}
+
+// ReportStage implements pipeline.Reporter
+func (r *progressReporter) ReportStage(_ context.Context, state *pipeline.State) error {
+	state.Lock()
+	status := state.Stage.Status
+	state.Unlock()
+	if status == drone.StatusPending || status == drone.StatusRunning {
+		return nil
+	}
+	r.Lock()
+	defer r.Unlock()
+	fmt.Fprintf(r.out, "pipeline %s\n", r.colorize(status))
+	return nil
+}
+
+// ReportStep implements pipeline.Reporter
+func (r *progressReporter) ReportStep(_ context.Context, state *pipeline.State, name string) error {
+	state.Lock()
+	total := len(state.Stage.Steps)
+	var status string
+	for _, s := range state.Stage.Steps {
+		if s.Name == name {
+			status = s.Status
+			break
+		}
+	}
+	state.Unlock()
+
+	r.Lock()
+	defer r.Unlock()
+	switch status {
+	case drone.StatusRunning:
+		r.started++
+		fmt.Fprintf(r.out, "[%d/%d] running %s\n", r.started, total, name)
+	case drone.StatusSkipped:
+		fmt.Fprintf(r.out, "[-/%d] %s %s\n", total, r.colorize(status), name)
+	default:
+		fmt.Fprintf(r.out, "[%d/%d] %s %s\n", r.started, total, r.colorize(status), name)
+	}
+	return nil
+}
+
+// colorize colors the status when writing to a terminal
+func (r *progressReporter) colorize(status string) string {
+	if !r.tty {
+		return status
+	}
+	switch status {
+	case drone.StatusPassing:
+		return "\033[32m" + status + "\033[0m"
+	case drone.StatusFailing, drone.StatusError, drone.StatusKilled:
+		return "\033[31m" + status + "\033[0m"
+	default:
+		return "\033[33m" + status + "\033[0m"
+	}
+}
@@ -0,0 +1,124 @@
+package drone
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/docker/docker/api/types"
+	"github.com/drone-runners/drone-runner-docker/engine"
+	"github.com/drone/runner-go/pipeline/runtime"
+)
+
+// allSteps is the key of the retries of all the steps
+const allSteps = "*"
+
+// parseStepRetries parses the retries of the failed steps that are defined in
+// --step-retries=N format for all the steps or --step-retries=step:N format
+// for a step, keyed by the step name.
+func parseStepRetries(retries []string) (map[string]int, error) {
+	byStep := map[string]int{}
+	for _, s := range retries {
+		step, n := allSteps, s
+		if i := strings.LastIndex(s, ":"); i >= 0 {
+			step, n = s[:i], s[i+1:]
+		}
+		r, err := strconv.Atoi(n)
+		if err != nil || r < 0 || step == "" {
+			return nil, fmt.Errorf("invalid step retries '%s', expecting N or step:N", s)
+		}
+		byStep[step] = r
+	}
+	return byStep, nil
+}
+
+// retryEngine is a pipeline engine that re-runs the failed steps, with a linear
+// backoff, before they are reported failed. The steps whose failure is ignored
+// are not retried, and neither are the steps failing once the run is cancelled
+// or past its deadline, the grace period of the timeout is not for retries.
+type retryEngine struct {
+	runtime.Engine
+	retries  map[string]int
+	deadline time.Time
+	mu       sync.Mutex
+	attempts map[string]int
+}
+
+var _ runtime.Engine = (*retryEngine)(nil)
+
+func newRetryEngine(eng runtime.Engine, retries map[string]int, deadline time.Time) *retryEngine {
+	return &retryEngine{
+		Engine:   eng,
+		retries:  retries,
+		deadline: deadline,
+		attempts: map[string]int{},
+	}
+}
+
+// Run implements runtime.Engine
+func (r *retryEngine) Run(ctx context.Context, spec runtime.Spec, stepv runtime.Step, output io.Writer) (*runtime.State, error) {
+	step, ok := stepv.(*engine.Step)
+	if !ok || step.ErrPolicy == runtime.ErrIgnore {
+		return r.Engine.Run(ctx, spec, stepv, output)
+	}
+	retries, ok := r.retries[step.Name]
+	if !ok {
+		retries = r.retries[allSteps]
+	}
+	for attempt := 1; ; attempt++ {
+		r.mu.Lock()
+		r.attempts[step.Name] = attempt
+		r.mu.Unlock()
+		state, err := r.Engine.Run(ctx, spec, stepv, output)
+		// the exit code 78 skips the remaining steps on purpose, it is not a failure
+		if err == nil && state != nil && (state.ExitCode == 0 || state.ExitCode == 78) {
+			return state, err
+		}
+		if attempt > retries || r.stopped(ctx) {
+			return state, err
+		}
+		if state != nil {
+			log.Warnf("Step %s exited with code %d, retrying %d of %d", step.Name, state.ExitCode, attempt, retries)
+		} else {
+			log.Warnf("Step %s failed, retrying %d of %d,%v", step.Name, attempt, retries, err)
+		}
+		// the container of the failed attempt is removed to run the step again
+		if dockerCli != nil {
+			if err := dockerCli.ContainerRemove(ctx, step.ID, types.ContainerRemoveOptions{Force: true}); err != nil {
+				log.Debugf("Unable to remove the container of step %s,%v", step.Name, err)
+			}
+		}
+		backoff := time.Duration(attempt) * time.Second
+		if !r.deadline.IsZero() && time.Until(r.deadline) < backoff {
+			backoff = time.Until(r.deadline)
+		}
+		select {
+		case <-ctx.Done():
+			return state, err
+		case <-time.After(backoff):
+		}
+		if r.stopped(ctx) {
+			return state, err
+		}
+	}
+}
+
+// stopped tells whether the run is cancelled, e.g. on a signal, or past its deadline
+func (r *retryEngine) stopped(ctx context.Context) bool {
+	return ctx.Err() != nil || (!r.deadline.IsZero() && !time.Now().Before(r.deadline))
+}
+
+// Attempts returns the number of times each step was run keyed by the step name
+func (r *retryEngine) Attempts() map[string]int {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	attempts := make(map[string]int, len(r.attempts))
+	for k, v := range r.attempts {
+		attempts[k] = v
+	}
+	return attempts
+}
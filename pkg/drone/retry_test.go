@@ -0,0 +1,49 @@
+package drone
+
+import (
+	"context"
+	"io"
+	"reflect"
+	"testing"
+	"time"
+
+	"github.com/drone-runners/drone-runner-docker/engine"
+)
+
+func TestRetryEngineTimeout(t *testing.T) {
+	log.SetOutput(io.Discard)
+	step := &engine.Step{Name: "build"}
+	tests := []struct {
+		name     string
+		deadline time.Duration
+		cancel   bool
+		want     []string
+	}{
+		{"retried", time.Minute, false, []string{"build", "build"}},
+		{"past the deadline", -time.Second, false, []string{"build"}},
+		{"deadline during the backoff", 100 * time.Millisecond, false, []string{"build"}},
+		{"cancelled", time.Minute, true, []string{"build"}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			eng := &stubEngine{exitCodes: map[string]int{"build": 1}}
+			retry := newRetryEngine(eng, map[string]int{allSteps: 1}, time.Now().Add(tt.deadline))
+			ctx, cancel := context.WithCancel(context.Background())
+			defer cancel()
+			if tt.cancel {
+				cancel()
+			}
+			start := time.Now()
+			state, err := retry.Run(ctx, nil, step, io.Discard)
+			if err != nil || state.ExitCode != 1 {
+				t.Errorf("expecting the failed step, got %+v, %v", state, err)
+			}
+			if got := eng.Ran(); !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("ran %v, want %v", got, tt.want)
+			}
+			if tt.deadline > 0 && tt.deadline < time.Second && time.Since(start) > time.Second {
+				t.Errorf("expecting the backoff to stop at the deadline, took %s", time.Since(start))
+			}
+		})
+	}
+}
@@ -0,0 +1,177 @@
+package drone
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io"
+	"os"
+	"path"
+	"path/filepath"
+	"text/tabwriter"
+	"time"
+
+	"github.com/bfontaine/jsons"
+	"github.com/drone/drone-go/drone"
+	"github.com/urfave/cli/v2"
+)
+
+// maxRunHistory is the number of runs kept in the run history, the older runs are pruned
+const maxRunHistory = 100
+
+// runRecord is a run of the run history
+type runRecord struct {
+	Timestamp  int64  `json:"timestamp"`
+	Source     string `json:"source"`
+	Stage      string `json:"stage"`
+	Status     string `json:"status"`
+	Provenance string `json:"provenance,omitempty"`
+	Log        string `json:"log,omitempty"`
+	Summary    string `json:"summary,omitempty"`
+	OutputDir  string `json:"outputDir,omitempty"`
+}
+
+// RunsCommand exports the runs command.
+var RunsCommand = &cli.Command{
+	Name:  "runs",
+	Usage: "list the recent runs with their provenance and log files, most recent first",
+	Flags: []cli.Flag{
+		outputFlag(),
+		&cli.IntFlag{
+			Name:  "runs-limit",
+			Usage: "maximum number of runs listed, 0 lists all the runs of the history",
+			Value: 20,
+		},
+		&cli.BoolFlag{
+			Name:  "prune",
+			Usage: "remove all the runs from the history",
+		},
+	},
+	Action: runs,
+}
+
+func runs(cliContext *cli.Context) error {
+	output := cliContext.String("output")
+	if err := validateOutput(output); err != nil {
+		return err
+	}
+	if cliContext.Bool("prune") {
+		if err := os.Remove(runHistoryFile()); err != nil && !os.IsNotExist(err) {
+			return fmt.Errorf("unable to prune the run history: %w", err)
+		}
+		return nil
+	}
+	history, err := readRunHistory()
+	if err != nil {
+		return err
+	}
+	// most recent first
+	recent := make([]runRecord, 0, len(history))
+	for i := len(history) - 1; i >= 0; i-- {
+		recent = append(recent, history[i])
+	}
+	if limit := cliContext.Int("runs-limit"); limit > 0 && len(recent) > limit {
+		recent = recent[:limit]
+	}
+	if output == outputJSON {
+		enc := json.NewEncoder(os.Stdout)
+		enc.SetIndent("", "  ")
+		return enc.Encode(recent)
+	}
+	return writeRuns(os.Stdout, recent)
+}
+
+// writeRuns writes the runs as a table
+func writeRuns(w io.Writer, runs []runRecord) error {
+	tw := tabwriter.NewWriter(w, 0, 4, 2, ' ', 0)
+	fmt.Fprintln(tw, "TIME\tSOURCE\tSTAGE\tSTATUS\tPROVENANCE\tLOG")
+	for _, r := range runs {
+		fmt.Fprintf(tw, "%s\t%s\t%s\t%s\t%s\t%s\n", time.Unix(r.Timestamp, 0).Format(time.RFC3339), r.Source, r.Stage, r.Status, r.Provenance, r.Log)
+	}
+	return tw.Flush()
+}
+
+// runHistoryFile returns the file of the run history, one json run per line
+func runHistoryFile() string {
+	return path.Join(droneCILogsDir, "runs.jsonl")
+}
+
+// readRunHistory reads the runs of the history, oldest first
+func readRunHistory() ([]runRecord, error) {
+	history := []runRecord{}
+	if _, err := os.Stat(runHistoryFile()); os.IsNotExist(err) {
+		return history, nil
+	}
+	fr := jsons.NewFileReader(runHistoryFile())
+	if err := fr.Open(); err != nil {
+		return nil, err
+	}
+	defer fr.Close()
+	for {
+		var r runRecord
+		err := fr.Next(&r)
+		if err == io.EOF {
+			return history, nil
+		}
+		if err != nil {
+			return nil, fmt.Errorf("unable to read the run history %s: %w", runHistoryFile(), err)
+		}
+		history = append(history, r)
+	}
+}
+
+// recordRun appends the run to the history, pruning the oldest runs beyond
+// maxRunHistory. The files are recorded with their absolute path so that they
+// can be found from any directory.
+func recordRun(r runRecord) error {
+	r.Source = absRunPath(r.Source)
+	r.Provenance = absRunPath(r.Provenance)
+	r.Log = absRunPath(r.Log)
+	r.Summary = absRunPath(r.Summary)
+	r.OutputDir = absRunPath(r.OutputDir)
+	history, err := readRunHistory()
+	if err != nil {
+		return err
+	}
+	history = append(history, r)
+	if len(history) > maxRunHistory {
+		history = history[len(history)-maxRunHistory:]
+	}
+	return writeRunHistory(history)
+}
+
+// writeRunHistory writes the runs of the history, oldest first
+func writeRunHistory(history []runRecord) error {
+	var b bytes.Buffer
+	enc := json.NewEncoder(&b)
+	for _, h := range history {
+		if err := enc.Encode(h); err != nil {
+			return err
+		}
+	}
+	if err := os.MkdirAll(droneCILogsDir, 0o755); err != nil {
+		return err
+	}
+	return os.WriteFile(runHistoryFile(), b.Bytes(), 0o644)
+}
+
+// runStatus returns the status of the run, the stage of the runs without
+// failures has no status as the stage status is only set on failure
+func runStatus(status string) string {
+	if status == "" {
+		return drone.StatusPassing
+	}
+	return status
+}
+
+// absRunPath returns the absolute path of the file, stdin, stdout and the
+// unset files are kept as is
+func absRunPath(p string) string {
+	if p == "" || p == stdoutPath {
+		return p
+	}
+	if abs, err := filepath.Abs(p); err == nil {
+		return abs
+	}
+	return p
+}
@@ -0,0 +1,82 @@
+package drone
+
+import (
+	"fmt"
+	"strings"
+)
+
+// selector operators
+const (
+	selectorEquals    = "="
+	selectorNotEquals = "!="
+	selectorExists    = "exists"
+	selectorNotExists = "!exists"
+)
+
+// selectorRequirement is a requirement on a label of the step
+type selectorRequirement struct {
+	Key      string
+	Operator string
+	Value    string
+}
+
+// labelSelector selects the steps whose labels match all its requirements
+type labelSelector []selectorRequirement
+
+// parseLabelSelector parses the comma separated requirements of the label
+// selector, each one of key=value, key==value, key!=value, key or !key.
+func parseLabelSelector(s string) (labelSelector, error) {
+	if strings.TrimSpace(s) == "" {
+		return nil, nil
+	}
+	var sel labelSelector
+	for _, part := range strings.Split(s, ",") {
+		part = strings.TrimSpace(part)
+		var r selectorRequirement
+		switch {
+		case strings.Contains(part, "!="):
+			kv := strings.SplitN(part, "!=", 2)
+			r = selectorRequirement{Key: kv[0], Operator: selectorNotEquals, Value: kv[1]}
+		case strings.Contains(part, "="):
+			kv := strings.SplitN(strings.Replace(part, "==", "=", 1), "=", 2)
+			r = selectorRequirement{Key: kv[0], Operator: selectorEquals, Value: kv[1]}
+		case strings.HasPrefix(part, "!"):
+			r = selectorRequirement{Key: strings.TrimPrefix(part, "!"), Operator: selectorNotExists}
+		default:
+			r = selectorRequirement{Key: part, Operator: selectorExists}
+		}
+		r.Key, r.Value = strings.TrimSpace(r.Key), strings.TrimSpace(r.Value)
+		if r.Key == "" || strings.ContainsAny(r.Key, "!=") || strings.ContainsAny(r.Value, "!=") {
+			return nil, fmt.Errorf("invalid label selector '%s', expecting comma separated key=value, key!=value, key or !key", s)
+		}
+		sel = append(sel, r)
+	}
+	return sel, nil
+}
+
+// Matches checks if the labels match all the requirements of the selector,
+// a missing label matches key!=value.
+func (sel labelSelector) Matches(labels map[string]string) bool {
+	for _, r := range sel {
+		v, ok := labels[r.Key]
+		switch r.Operator {
+		case selectorEquals:
+			if !ok || v != r.Value {
+				return false
+			}
+		case selectorNotEquals:
+			if ok && v == r.Value {
+				return false
+			}
+		case selectorExists:
+			if !ok {
+				return false
+			}
+		case selectorNotExists:
+			if ok {
+				return false
+			}
+		}
+	}
+	return true
+}
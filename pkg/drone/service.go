@@ -0,0 +1,98 @@
+package drone
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"sync"
+	"time"
+
+	"github.com/docker/docker/api/types"
+	"github.com/drone-runners/drone-runner-docker/engine"
+	"github.com/drone/runner-go/pipeline/runtime"
+)
+
+// serviceEngine is a pipeline engine that watches the service containers and
+// halts the build, by cancelling it, once a service exits non-zero or fails
+// its health check.
+type serviceEngine struct {
+	runtime.Engine
+	cancel  context.CancelFunc
+	mu      sync.Mutex
+	failure string
+}
+
+var _ runtime.Engine = (*serviceEngine)(nil)
+
+// Run implements runtime.Engine, the started service containers are watched until the build ends
+func (s *serviceEngine) Run(ctx context.Context, spec runtime.Spec, stepv runtime.Step, output io.Writer) (*runtime.State, error) {
+	state, err := s.Engine.Run(ctx, spec, stepv, output)
+	step, ok := stepv.(*engine.Step)
+	if !ok || step.Labels[labelService] != "true" {
+		return state, err
+	}
+	switch {
+	case err != nil:
+		s.fail(fmt.Sprintf("service %s failed to start: %v", step.Name, err))
+	case state != nil && state.Exited && state.ExitCode != 0:
+		s.fail(fmt.Sprintf("service %s exited with code %d", step.Name, state.ExitCode))
+	case dockerCli != nil:
+		go s.watch(ctx, step)
+	}
+	return state, err
+}
+
+// watch polls the service container until it exits non-zero, becomes unhealthy or the build ends
+func (s *serviceEngine) watch(ctx context.Context, step *engine.Step) {
+	ticker := time.NewTicker(time.Second)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+		c, err := dockerCli.ContainerInspect(ctx, step.ID)
+		// the container is removed once the build ends
+		if err != nil || c.State == nil {
+			return
+		}
+		if failure := serviceFailure(step.Name, c.State); failure != "" {
+			s.fail(failure)
+			return
+		}
+		if !c.State.Running {
+			return
+		}
+	}
+}
+
+// serviceFailure describes the failure of the service container, empty when the service has not failed
+func serviceFailure(name string, state *types.ContainerState) string {
+	switch {
+	case !state.Running && state.ExitCode != 0:
+		return fmt.Sprintf("service %s exited with code %d", name, state.ExitCode)
+	case state.Health != nil && state.Health.Status == types.Unhealthy:
+		return fmt.Sprintf("service %s failed its health check", name)
+	}
+	return ""
+}
+
+// fail records the first service failure and cancels the build
+func (s *serviceEngine) fail(failure string) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.failure != "" {
+		return
+	}
+	s.failure = failure
+	log.Errorf("Halting the build, %s", failure)
+	s.cancel()
+}
+
+// Failure returns the service failure that halted the build, empty when no service failed
+func (s *serviceEngine) Failure() string {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.failure
+}
@@ -0,0 +1,328 @@
+package drone
+
+import (
+	"bytes"
+	"context"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/sha256"
+	"crypto/x509"
+	"encoding/base64"
+	"encoding/hex"
+	"encoding/json"
+	"encoding/pem"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+
+	intoto "github.com/in-toto/in-toto-golang/in_toto"
+	"github.com/secure-systems-lab/go-securesystemslib/dsse"
+)
+
+const (
+	// defaultFulcioURL is the public good Fulcio instance issuing the signing certificates
+	defaultFulcioURL = "https://fulcio.sigstore.dev"
+	// defaultRekorURL is the public good Rekor transparency log
+	defaultRekorURL = "https://rekor.sigstore.dev"
+	// sigstoreBundleMediaType is the media type of the signed provenance
+	sigstoreBundleMediaType = "application/vnd.dev.sigstore.bundle+json;version=0.1"
+	// cycloneDXPayloadType is the DSSE payload type of a CycloneDX BOM
+	cycloneDXPayloadType = "application/vnd.cyclonedx+json"
+)
+
+// sigstoreBundle is the signed provenance, the DSSE envelope with the Fulcio
+// certificate of the signature and the Rekor entry recording it.
+type sigstoreBundle struct {
+	MediaType            string                     `json:"mediaType"`
+	VerificationMaterial bundleVerificationMaterial `json:"verificationMaterial"`
+	DSSEEnvelope         dsse.Envelope              `json:"dsseEnvelope"`
+}
+
+type bundleVerificationMaterial struct {
+	X509CertificateChain bundleCertificateChain `json:"x509CertificateChain"`
+	TlogEntries          []bundleTlogEntry      `json:"tlogEntries"`
+}
+
+type bundleCertificateChain struct {
+	Certificates []bundleCertificate `json:"certificates"`
+}
+
+type bundleCertificate struct {
+	RawBytes []byte `json:"rawBytes"`
+}
+
+type bundleTlogEntry struct {
+	LogIndex string `json:"logIndex"`
+	LogID    struct {
+		KeyID []byte `json:"keyId"`
+	} `json:"logId"`
+	KindVersion struct {
+		Kind    string `json:"kind"`
+		Version string `json:"version"`
+	} `json:"kindVersion"`
+	IntegratedTime   string `json:"integratedTime"`
+	InclusionPromise struct {
+		SignedEntryTimestamp []byte `json:"signedEntryTimestamp"`
+	} `json:"inclusionPromise"`
+	CanonicalizedBody []byte `json:"canonicalizedBody"`
+}
+
+// keylessSigner signs the attestation with an ephemeral key certified by Fulcio
+// for the identity of the OIDC token and records the signature in Rekor.
+type keylessSigner struct {
+	FulcioURL     string
+	RekorURL      string
+	IdentityToken string
+	client        *http.Client
+}
+
+// newKeylessSigner returns the signer configured by the flags, the calls go
+// through the same proxies as the registry calls.
+func newKeylessSigner(commy *execCommand) *keylessSigner {
+	return &keylessSigner{
+		FulcioURL:     strings.TrimSuffix(commy.FulcioURL, "/"),
+		RekorURL:      strings.TrimSuffix(commy.RekorURL, "/"),
+		IdentityToken: commy.IdentityToken,
+		client:        &http.Client{Transport: registryTransport},
+	}
+}
+
+// Sign wraps the attestation in a signed DSSE envelope and returns it as a
+// sigstore bundle.
+func (s *keylessSigner) Sign(ctx context.Context, statement interface{}, payloadType string) (*sigstoreBundle, error) {
+	subject, err := tokenSubject(s.IdentityToken)
+	if err != nil {
+		return nil, err
+	}
+	priv, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		return nil, fmt.Errorf("unable to generate the signing key: %w", err)
+	}
+	cert, err := s.signingCertificate(ctx, priv, subject)
+	if err != nil {
+		return nil, err
+	}
+
+	payload, err := json.Marshal(statement)
+	if err != nil {
+		return nil, fmt.Errorf("unable to encode attestation json: %w", err)
+	}
+	digest := sha256.Sum256(dsse.PAE(payloadType, payload))
+	sig, err := ecdsa.SignASN1(rand.Reader, priv, digest[:])
+	if err != nil {
+		return nil, fmt.Errorf("unable to sign the provenance: %w", err)
+	}
+	entry, err := s.recordSignature(ctx, sig, cert, digest[:])
+	if err != nil {
+		return nil, err
+	}
+	log.Infof("Provenance signed as %s, Rekor log index %s", subject, entry.LogIndex)
+
+	return &sigstoreBundle{
+		MediaType: sigstoreBundleMediaType,
+		VerificationMaterial: bundleVerificationMaterial{
+			X509CertificateChain: bundleCertificateChain{
+				Certificates: []bundleCertificate{{RawBytes: cert.Raw}},
+			},
+			TlogEntries: []bundleTlogEntry{*entry},
+		},
+		DSSEEnvelope: dsse.Envelope{
+			PayloadType: payloadType,
+			Payload:     base64.StdEncoding.EncodeToString(payload),
+			Signatures: []dsse.Signature{{
+				Sig: base64.StdEncoding.EncodeToString(sig),
+			}},
+		},
+	}, nil
+}
+
+// signingCertificate requests the short lived certificate of the key from
+// Fulcio, proving the possession of the key by signing the token subject.
+func (s *keylessSigner) signingCertificate(ctx context.Context, priv *ecdsa.PrivateKey, subject string) (*x509.Certificate, error) {
+	pub, err := x509.MarshalPKIXPublicKey(&priv.PublicKey)
+	if err != nil {
+		return nil, err
+	}
+	h := sha256.Sum256([]byte(subject))
+	proof, err := ecdsa.SignASN1(rand.Reader, priv, h[:])
+	if err != nil {
+		return nil, err
+	}
+	req := map[string]interface{}{
+		"credentials": map[string]string{
+			"oidcIdentityToken": s.IdentityToken,
+		},
+		"publicKeyRequest": map[string]interface{}{
+			"publicKey": map[string]string{
+				"algorithm": "ECDSA",
+				"content":   string(pem.EncodeToMemory(&pem.Block{Type: "PUBLIC KEY", Bytes: pub})),
+			},
+			"proofOfPossession": base64.StdEncoding.EncodeToString(proof),
+		},
+	}
+	var resp struct {
+		Embedded *struct {
+			Chain struct {
+				Certificates []string `json:"certificates"`
+			} `json:"chain"`
+		} `json:"signedCertificateEmbeddedSct"`
+		Detached *struct {
+			Chain struct {
+				Certificates []string `json:"certificates"`
+			} `json:"chain"`
+		} `json:"signedCertificateDetachedSct"`
+	}
+	if err := s.post(ctx, "Fulcio", s.FulcioURL+"/api/v2/signingCert", req, &resp); err != nil {
+		return nil, err
+	}
+	var chain []string
+	switch {
+	case resp.Embedded != nil:
+		chain = resp.Embedded.Chain.Certificates
+	case resp.Detached != nil:
+		chain = resp.Detached.Chain.Certificates
+	}
+	if len(chain) == 0 {
+		return nil, fmt.Errorf("no signing certificate returned by Fulcio at %s", s.FulcioURL)
+	}
+	block, _ := pem.Decode([]byte(chain[0]))
+	if block == nil {
+		return nil, fmt.Errorf("invalid signing certificate returned by Fulcio at %s", s.FulcioURL)
+	}
+	cert, err := x509.ParseCertificate(block.Bytes)
+	if err != nil {
+		return nil, fmt.Errorf("invalid signing certificate returned by Fulcio at %s: %w", s.FulcioURL, err)
+	}
+	return cert, nil
+}
+
+// recordSignature records the signature of the digest as a hashedrekord
+// entry in Rekor and returns the entry as a bundle transparency log entry.
+func (s *keylessSigner) recordSignature(ctx context.Context, sig []byte, cert *x509.Certificate, digest []byte) (*bundleTlogEntry, error) {
+	certPEM := pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: cert.Raw})
+	req := map[string]interface{}{
+		"apiVersion": "0.0.1",
+		"kind":       "hashedrekord",
+		"spec": map[string]interface{}{
+			"signature": map[string]interface{}{
+				"content": base64.StdEncoding.EncodeToString(sig),
+				"publicKey": map[string]string{
+					"content": base64.StdEncoding.EncodeToString(certPEM),
+				},
+			},
+			"data": map[string]interface{}{
+				"hash": map[string]string{
+					"algorithm": "sha256",
+					"value":     hex.EncodeToString(digest),
+				},
+			},
+		},
+	}
+	var resp map[string]struct {
+		Body           string `json:"body"`
+		IntegratedTime int64  `json:"integratedTime"`
+		LogID          string `json:"logID"`
+		LogIndex       int64  `json:"logIndex"`
+		Verification   struct {
+			SignedEntryTimestamp []byte `json:"signedEntryTimestamp"`
+		} `json:"verification"`
+	}
+	if err := s.post(ctx, "Rekor", s.RekorURL+"/api/v1/log/entries", req, &resp); err != nil {
+		return nil, err
+	}
+	for _, e := range resp {
+		body, err := base64.StdEncoding.DecodeString(e.Body)
+		if err != nil {
+			return nil, fmt.Errorf("invalid entry returned by Rekor at %s: %w", s.RekorURL, err)
+		}
+		logID, err := hex.DecodeString(e.LogID)
+		if err != nil {
+			return nil, fmt.Errorf("invalid entry returned by Rekor at %s: %w", s.RekorURL, err)
+		}
+		entry := &bundleTlogEntry{
+			LogIndex:          strconv.FormatInt(e.LogIndex, 10),
+			IntegratedTime:    strconv.FormatInt(e.IntegratedTime, 10),
+			CanonicalizedBody: body,
+		}
+		entry.LogID.KeyID = logID
+		entry.KindVersion.Kind = "hashedrekord"
+		entry.KindVersion.Version = "0.0.1"
+		entry.InclusionPromise.SignedEntryTimestamp = e.Verification.SignedEntryTimestamp
+		return entry, nil
+	}
+	return nil, fmt.Errorf("no entry returned by Rekor at %s", s.RekorURL)
+}
+
+// post posts the request json to the service and decodes the response json
+func (s *keylessSigner) post(ctx context.Context, service, u string, in, out interface{}) error {
+	b, err := json.Marshal(in)
+	if err != nil {
+		return err
+	}
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, u, bytes.NewReader(b))
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Content-Type", "application/json")
+	req.Header.Set("Accept", "application/json")
+	resp, err := s.client.Do(req)
+	if err != nil {
+		return fmt.Errorf("unable to reach %s at %s: %w", service, u, err)
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode < 200 || resp.StatusCode > 299 {
+		msg, _ := io.ReadAll(io.LimitReader(resp.Body, 4096))
+		return fmt.Errorf("%s at %s answered %s %s", service, u, resp.Status, strings.TrimSpace(string(msg)))
+	}
+	if err := json.NewDecoder(resp.Body).Decode(out); err != nil {
+		return fmt.Errorf("invalid response of %s at %s: %w", service, u, err)
+	}
+	return nil
+}
+
+// tokenSubject returns the identity the certificate is issued for, the email
+// claim of the OIDC token or its subject.
+func tokenSubject(token string) (string, error) {
+	parts := strings.Split(token, ".")
+	if len(parts) != 3 {
+		return "", fmt.Errorf("invalid identity token, expecting a JWT")
+	}
+	b, err := base64.RawURLEncoding.DecodeString(strings.TrimRight(parts[1], "="))
+	if err != nil {
+		return "", fmt.Errorf("invalid identity token claims: %w", err)
+	}
+	var claims struct {
+		Email   string `json:"email"`
+		Subject string `json:"sub"`
+	}
+	if err := json.Unmarshal(b, &claims); err != nil {
+		return "", fmt.Errorf("invalid identity token claims: %w", err)
+	}
+	if claims.Email != "" {
+		return claims.Email, nil
+	}
+	if claims.Subject == "" {
+		return "", fmt.Errorf("identity token has neither an email nor a subject claim")
+	}
+	return claims.Subject, nil
+}
+
+// signingPayloadType is the DSSE payload type of the provenance format
+func signingPayloadType(format string) string {
+	if format == formatCycloneDX {
+		return cycloneDXPayloadType
+	}
+	return intoto.PayloadType
+}
+
+// validateServiceURL checks the flag value is an http(s) URL
+func validateServiceURL(flag, u string) error {
+	if p, err := url.Parse(u); err != nil || (p.Scheme != "http" && p.Scheme != "https") || p.Host == "" {
+		return fmt.Errorf("invalid --%s '%s', expecting an http(s) URL", flag, u)
+	}
+	return nil
+}
@@ -0,0 +1,100 @@
+package drone
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	osexec "os/exec"
+	"strings"
+
+	"github.com/drone-runners/drone-runner-docker/engine"
+	"github.com/drone-runners/drone-runner-docker/engine/resource"
+)
+
+// changedFiles returns the files of the git work tree changed since the ref,
+// including the uncommitted and the untracked files, relative to the root of
+// the repository like the paths conditions.
+func changedFiles(ctx context.Context, ref string) ([]string, error) {
+	diff, err := git(ctx, "diff", "--name-only", ref, "--")
+	if err != nil {
+		return nil, err
+	}
+	untracked, err := git(ctx, "ls-files", "--others", "--exclude-standard", "--full-name")
+	if err != nil {
+		return nil, err
+	}
+	return append(diff, untracked...), nil
+}
+
+// git runs the git command and returns the lines of its output
+func git(ctx context.Context, args ...string) ([]string, error) {
+	var stderr bytes.Buffer
+	cmd := osexec.CommandContext(ctx, "git", args...)
+	cmd.Stderr = &stderr
+	out, err := cmd.Output()
+	if err != nil {
+		return nil, fmt.Errorf("git %s: %w %s", strings.Join(args, " "), err, strings.TrimSpace(stderr.String()))
+	}
+	var lines []string
+	for _, l := range strings.Split(string(out), "\n") {
+		if l != "" {
+			lines = append(lines, l)
+		}
+	}
+	return lines, nil
+}
+
+// unchangedSteps returns the steps whose when paths condition matches none of the
+// changed files. The steps without a paths condition always run as well as the
+// dependencies of the steps that run. No step is returned when none of the steps
+// has a paths condition, as the steps can not be mapped to the changed files.
+func unchangedSteps(p *resource.Pipeline, spec *engine.Spec, changed []string) []string {
+	paths := map[string]bool{}
+	unchanged := map[string]bool{}
+	for _, s := range p.Steps {
+		cond := s.When.Paths
+		if len(cond.Include) == 0 && len(cond.Exclude) == 0 {
+			continue
+		}
+		paths[s.Name] = true
+		unchanged[s.Name] = true
+		for _, f := range changed {
+			if cond.Match(f) {
+				unchanged[s.Name] = false
+				break
+			}
+		}
+	}
+	if len(paths) == 0 {
+		log.Warnln("No step has a paths condition, --since runs all the steps")
+		return nil
+	}
+
+	// keep the dependencies of the steps that run
+	deps := map[string][]string{}
+	for _, s := range spec.Steps {
+		deps[s.Name] = s.DependsOn
+	}
+	var keep func(name string)
+	keep = func(name string) {
+		for _, d := range deps[name] {
+			if unchanged[d] {
+				unchanged[d] = false
+				keep(d)
+			}
+		}
+	}
+	for _, s := range spec.Steps {
+		if !unchanged[s.Name] {
+			keep(s.Name)
+		}
+	}
+
+	var names []string
+	for _, s := range spec.Steps {
+		if unchanged[s.Name] {
+			names = append(names, s.Name)
+		}
+	}
+	return names
+}
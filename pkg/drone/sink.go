@@ -0,0 +1,253 @@
+package drone
+
+import (
+	"bytes"
+	"compress/gzip"
+	"context"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"os"
+	"path"
+	"strings"
+	"time"
+
+	"github.com/google/go-containerregistry/pkg/crane"
+	"github.com/google/go-containerregistry/pkg/name"
+	"github.com/google/go-containerregistry/pkg/v1/empty"
+	"github.com/google/go-containerregistry/pkg/v1/mutate"
+	"github.com/google/go-containerregistry/pkg/v1/static"
+	"github.com/google/go-containerregistry/pkg/v1/types"
+	intoto "github.com/in-toto/in-toto-golang/in_toto"
+	"github.com/secure-systems-lab/go-securesystemslib/dsse"
+)
+
+// inTotoMediaType is the media type of the attestation attached to an image
+const inTotoMediaType types.MediaType = "application/vnd.in-toto+json"
+
+// ProvenanceSink is a destination the attestation is written to
+type ProvenanceSink interface {
+	Write(ctx context.Context, statement interface{}) error
+}
+
+var (
+	_ ProvenanceSink = (*FileSink)(nil)
+	_ ProvenanceSink = (*StdoutSink)(nil)
+	_ ProvenanceSink = (*OCISink)(nil)
+	_ ProvenanceSink = (*HTTPSink)(nil)
+)
+
+// FileSink writes the attestation json to a file, indented or minified and
+// optionally gzip compressed with a .gz suffix. When Checksum is set a
+// <file>.sha256 sidecar, in sha256sum format, is written with the digest of
+// the exact bytes written.
+type FileSink struct {
+	Path     string
+	Indent   bool
+	Gzip     bool
+	Checksum bool
+}
+
+// Write implements ProvenanceSink
+func (s *FileSink) Write(_ context.Context, statement interface{}) error {
+	b, err := encodeStatement(statement, s.Indent, s.Gzip)
+	if err != nil {
+		return err
+	}
+	fp := s.Path
+	if s.Gzip && !strings.HasSuffix(fp, ".gz") {
+		fp += ".gz"
+	}
+	if err := os.WriteFile(fp, b, 0o644); err != nil {
+		return err
+	}
+	log.Infof("Provenance written to %s", fp)
+	if s.Checksum {
+		sum := fmt.Sprintf("%x  %s\n", sha256.Sum256(b), path.Base(fp))
+		if err := os.WriteFile(fp+".sha256", []byte(sum), 0o644); err != nil {
+			return err
+		}
+		log.Infof("Provenance checksum written to %s.sha256", fp)
+	}
+	return nil
+}
+
+// StdoutSink writes the attestation json to stdout, indented or minified and
+// optionally gzip compressed
+type StdoutSink struct {
+	Out    io.Writer
+	Indent bool
+	Gzip   bool
+}
+
+// Write implements ProvenanceSink
+func (s *StdoutSink) Write(_ context.Context, statement interface{}) error {
+	b, err := encodeStatement(statement, s.Indent, s.Gzip)
+	if err != nil {
+		return err
+	}
+	_, err = s.Out.Write(b)
+	return err
+}
+
+// OCISink attaches the attestation to the image in its registry, the attestation
+// is pushed as a single layer artifact tagged sha256-<digest>.att next to the image.
+type OCISink struct {
+	Image string
+}
+
+// Write implements ProvenanceSink
+func (s *OCISink) Write(ctx context.Context, statement interface{}) error {
+	ref, err := name.ParseReference(s.Image)
+	if err != nil {
+		return fmt.Errorf("invalid attach image '%s' : %w", s.Image, err)
+	}
+	dig, err := crane.Digest(ref.Name(), craneOptions(ctx)...)
+	if err != nil {
+		return fmt.Errorf("unable to resolve the digest of attach image '%s' : %w", s.Image, err)
+	}
+	b, err := encodeStatement(statement, false, false)
+	if err != nil {
+		return err
+	}
+	img, err := mutate.AppendLayers(empty.Image, static.NewLayer(b, inTotoMediaType))
+	if err != nil {
+		return err
+	}
+	tag := ref.Context().Tag(strings.Replace(dig, ":", "-", 1) + ".att")
+	if err := crane.Push(img, tag.Name(), craneOptions(ctx)...); err != nil {
+		return fmt.Errorf("unable to attach the provenance to %s : %w", s.Image, err)
+	}
+	log.Infof("Provenance attached to %s as %s", s.Image, tag.Name())
+	return nil
+}
+
+// HTTPSink posts the attestation json to an URL with the headers, optionally
+// wrapped in an unsigned DSSE envelope. The post is retried on server errors.
+type HTTPSink struct {
+	URL     string
+	Headers map[string]string
+	DSSE    bool
+	Retries int
+}
+
+// Write implements ProvenanceSink
+func (s *HTTPSink) Write(ctx context.Context, statement interface{}) error {
+	if s.DSSE {
+		payload, err := json.Marshal(statement)
+		if err != nil {
+			return fmt.Errorf("unable to encode attestation json: %w", err)
+		}
+		statement = dsse.Envelope{
+			PayloadType: intoto.PayloadType,
+			Payload:     base64.StdEncoding.EncodeToString(payload),
+			Signatures:  []dsse.Signature{},
+		}
+	}
+	b, err := encodeStatement(statement, false, false)
+	if err != nil {
+		return err
+	}
+	for attempt := 0; ; attempt++ {
+		retry, err := s.post(ctx, b)
+		if err == nil {
+			log.Infof("Provenance posted to %s", s.URL)
+			return nil
+		}
+		if !retry || attempt >= s.Retries {
+			return err
+		}
+		log.Warnf("Retrying to post the provenance,%v", err)
+		select {
+		case <-ctx.Done():
+			return err
+		case <-time.After(time.Duration(attempt+1) * time.Second):
+		}
+	}
+}
+
+// post posts the attestation once, returns whether the post can be retried
+// i.e. the server was not reachable or answered with a server error.
+func (s *HTTPSink) post(ctx context.Context, b []byte) (bool, error) {
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, s.URL, bytes.NewReader(b))
+	if err != nil {
+		return false, err
+	}
+	req.Header.Set("Content-Type", "application/json")
+	for k, v := range s.Headers {
+		req.Header.Set(k, v)
+	}
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		return ctx.Err() == nil, fmt.Errorf("unable to post the provenance to %s : %w", s.URL, err)
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode < 200 || resp.StatusCode > 299 {
+		msg, _ := io.ReadAll(io.LimitReader(resp.Body, 4096))
+		return resp.StatusCode >= 500, fmt.Errorf("unable to post the provenance to %s : %s %s", s.URL, resp.Status, strings.TrimSpace(string(msg)))
+	}
+	return false, nil
+}
+
+// provenanceSinks returns the sinks selected by the flags, the file or stdout
+// sink for the provenance path and optionally the OCI and HTTP sinks.
+func provenanceSinks(commy *execCommand, fp string) []ProvenanceSink {
+	var sinks []ProvenanceSink
+	if fp == stdoutPath {
+		if commy.ProvenanceChecksum {
+			log.Warnln("Skipping the provenance checksum, the provenance is written to stdout")
+		}
+		sinks = append(sinks, &StdoutSink{
+			Out:    commy.Stdout,
+			Indent: commy.ProvenanceIndent,
+			Gzip:   commy.ProvenanceGzip,
+		})
+	} else {
+		sinks = append(sinks, &FileSink{
+			Path:     fp,
+			Indent:   commy.ProvenanceIndent,
+			Gzip:     commy.ProvenanceGzip,
+			Checksum: commy.ProvenanceChecksum,
+		})
+	}
+	if commy.ProvenanceAttach != "" {
+		sinks = append(sinks, &OCISink{Image: commy.ProvenanceAttach})
+	}
+	if commy.ProvenanceURL != "" {
+		sinks = append(sinks, &HTTPSink{
+			URL:     commy.ProvenanceURL,
+			Headers: commy.ProvenanceHeaders,
+			DSSE:    commy.ProvenanceDSSE,
+			Retries: commy.ProvenanceRetries,
+		})
+	}
+	return sinks
+}
+
+// encodeStatement encodes the attestation json, indented with two spaces or
+// minified, and optionally gzip compressed
+func encodeStatement(statement interface{}, indent, gz bool) ([]byte, error) {
+	var buf bytes.Buffer
+	enc := json.NewEncoder(&buf)
+	if indent {
+		enc.SetIndent("", "  ")
+	}
+	if err := enc.Encode(statement); err != nil {
+		return nil, fmt.Errorf("unable to encode attestation json: %w", err)
+	}
+	if !gz {
+		return buf.Bytes(), nil
+	}
+	var gzbuf bytes.Buffer
+	zw := gzip.NewWriter(&gzbuf)
+	if _, err := zw.Write(buf.Bytes()); err != nil {
+		return nil, err
+	}
+	if err := zw.Close(); err != nil {
+		return nil, err
+	}
+	return gzbuf.Bytes(), nil
+}
@@ -0,0 +1,55 @@
+package drone
+
+import (
+	"github.com/drone-runners/drone-runner-docker/engine"
+)
+
+// redactedSpec returns a copy of the compiled pipeline with the secret values,
+// the registry passwords and the environment variables holding a secret or a
+// credential redacted, so that it can be dumped for inspection.
+func redactedSpec(spec *engine.Spec, r *redactor) *engine.Spec {
+	var values []string
+	for _, s := range append(spec.Steps, spec.Internal...) {
+		for _, sec := range s.Secrets {
+			values = append(values, string(sec.Data))
+		}
+	}
+	r = r.withValues(values...)
+	redacted := *spec
+	redacted.Steps = redactedSteps(spec.Steps, r)
+	redacted.Internal = redactedSteps(spec.Internal, r)
+	return &redacted
+}
+
+// redactedSteps returns a redacted copy of the steps
+func redactedSteps(steps []*engine.Step, r *redactor) []*engine.Step {
+	redacted := make([]*engine.Step, 0, len(steps))
+	for _, s := range steps {
+		step := *s
+		secretEnvs := map[string]bool{}
+		step.Secrets = make([]*engine.Secret, 0, len(s.Secrets))
+		for _, sec := range s.Secrets {
+			secretEnvs[sec.Env] = true
+			step.Secrets = append(step.Secrets, &engine.Secret{
+				Name: sec.Name,
+				Env:  sec.Env,
+				Data: []byte(redactedValue),
+				Mask: sec.Mask,
+			})
+		}
+		step.Envs = make(map[string]string, len(s.Envs))
+		for k, v := range s.Envs {
+			if secretEnvs[k] {
+				v = redactedValue
+			}
+			step.Envs[k] = r.redact(k, v)
+		}
+		if s.Auth != nil {
+			auth := *s.Auth
+			auth.Password = redactedValue
+			step.Auth = &auth
+		}
+		redacted = append(redacted, &step)
+	}
+	return redacted
+}
@@ -0,0 +1,26 @@
+package drone
+
+import (
+	"fmt"
+
+	intoto "github.com/in-toto/in-toto-golang/in_toto"
+)
+
+// statementInTotoV1 is the in-toto v1 statement type
+const statementInTotoV1 = "https://in-toto.io/Statement/v1"
+
+// statementTypes are the known in-toto statement type URIs
+var statementTypes = []string{
+	intoto.StatementInTotoV01,
+	statementInTotoV1,
+}
+
+// validateStatementType checks if t is one of the known in-toto statement types
+func validateStatementType(t string) error {
+	for _, st := range statementTypes {
+		if t == st {
+			return nil
+		}
+	}
+	return fmt.Errorf("unknown statement type '%s', expecting one of %v", t, statementTypes)
+}
@@ -0,0 +1,202 @@
+package drone
+
+import (
+	"bufio"
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"github.com/google/go-containerregistry/pkg/crane"
+	"github.com/google/go-containerregistry/pkg/name"
+	intoto "github.com/in-toto/in-toto-golang/in_toto"
+	"github.com/in-toto/in-toto-golang/in_toto/slsa_provenance/common"
+)
+
+// digestLengths holds the supported digest algorithms and
+// the expected length of their hex encoded value.
+var digestLengths = map[string]int{
+	"sha256": 64,
+	"sha512": 128,
+}
+
+// parseSubjects parses the subjects passed via --subject and the
+// entries of the --subjects-file into in-toto subjects.
+func parseSubjects(entries []string, subjectsFile string) ([]intoto.Subject, error) {
+	if subjectsFile != "" {
+		fileEntries, err := readSubjectsFile(subjectsFile)
+		if err != nil {
+			return nil, err
+		}
+		entries = append(entries, fileEntries...)
+	}
+	var subjects []intoto.Subject
+	for _, e := range entries {
+		s, err := parseSubject(e)
+		if err != nil {
+			return nil, err
+		}
+		subjects = append(subjects, s)
+	}
+	return subjects, nil
+}
+
+// parseSubject parses a subject of the form name=algorithm:digest
+// e.g. app.tar.gz=sha256:9f86d0...
+func parseSubject(s string) (intoto.Subject, error) {
+	i := strings.LastIndex(s, "=")
+	if i <= 0 {
+		return intoto.Subject{}, fmt.Errorf("invalid subject '%s', expecting name=sha256:digest", s)
+	}
+	name := strings.TrimSpace(s[:i])
+	alg, dig, err := parseDigest(strings.TrimSpace(s[i+1:]))
+	if err != nil {
+		return intoto.Subject{}, fmt.Errorf("invalid subject '%s' : %w", s, err)
+	}
+	return intoto.Subject{
+		Name: name,
+		Digest: common.DigestSet{
+			alg: dig,
+		},
+	}, nil
+}
+
+// parseDigest splits and validates a digest of the form algorithm:hex
+func parseDigest(d string) (string, string, error) {
+	parts := strings.SplitN(d, ":", 2)
+	if len(parts) != 2 {
+		return "", "", fmt.Errorf("digest '%s' is not of the form algorithm:hex", d)
+	}
+	alg, dig := strings.ToLower(parts[0]), strings.ToLower(parts[1])
+	l, ok := digestLengths[alg]
+	if !ok {
+		return "", "", fmt.Errorf("unsupported digest algorithm '%s'", alg)
+	}
+	if _, err := hex.DecodeString(dig); err != nil || len(dig) != l {
+		return "", "", fmt.Errorf("digest '%s' is not a valid %s hex value", dig, alg)
+	}
+	return alg, dig, nil
+}
+
+// readSubjectsFile reads the subjects from a file. Each line is either
+// of the form name=sha256:digest or the output of sha256sum i.e.
+// "<digest>  <name>". Blank lines and lines starting with # are ignored.
+func readSubjectsFile(path string) ([]string, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, fmt.Errorf("unable to read subjects file: %w", err)
+	}
+	defer f.Close()
+
+	var entries []string
+	scanner := bufio.NewScanner(f)
+	for scanner.Scan() {
+		line := strings.TrimSpace(scanner.Text())
+		if line == "" || strings.HasPrefix(line, "#") {
+			continue
+		}
+		if fields := strings.Fields(line); len(fields) == 2 && !strings.Contains(line, "=") {
+			// sha256sum marks binary mode files with a leading "*"
+			line = fmt.Sprintf("%s=sha256:%s", strings.TrimPrefix(fields[1], "*"), fields[0])
+		}
+		entries = append(entries, line)
+	}
+	return entries, scanner.Err()
+}
+
+// imageSubject resolves the digest of the pushed image and returns it as
+// a subject named after the image repository. In offline mode the digest
+// is resolved from the local docker daemon.
+func imageSubject(ctx context.Context, image string, offline bool) (intoto.Subject, error) {
+	ref, err := name.ParseReference(image)
+	if err != nil {
+		return intoto.Subject{}, fmt.Errorf("invalid subject image '%s' : %w", image, err)
+	}
+	var dig string
+	if offline {
+		var ds common.DigestSet
+		ds, err = localImageDigests(ctx, ref.Name())
+		dig = "sha256:" + ds["sha256"]
+	} else {
+		dig, err = crane.Digest(ref.Name(), craneOptions(ctx)...)
+	}
+	if err != nil {
+		return intoto.Subject{}, fmt.Errorf("unable to resolve the digest of subject image '%s' : %w", image, err)
+	}
+	alg, d, err := parseDigest(dig)
+	if err != nil {
+		return intoto.Subject{}, err
+	}
+	return intoto.Subject{
+		Name: ref.Context().Name(),
+		Digest: common.DigestSet{
+			alg: d,
+		},
+	}, nil
+}
+
+// validateSubjectGlobs checks the syntax of the subject glob patterns
+func validateSubjectGlobs(patterns []string) error {
+	for _, p := range patterns {
+		if _, err := filepath.Match(p, ""); err != nil {
+			return fmt.Errorf("invalid subject glob '%s' : %w", p, err)
+		}
+	}
+	return nil
+}
+
+// globSubjects returns the files of the workspace directory matching the
+// patterns as subjects named after their path relative to the workspace.
+func globSubjects(dir string, patterns []string) ([]intoto.Subject, error) {
+	var subjects []intoto.Subject
+	seen := map[string]bool{}
+	for _, p := range patterns {
+		matches, err := filepath.Glob(filepath.Join(dir, p))
+		if err != nil {
+			return nil, fmt.Errorf("invalid subject glob '%s' : %w", p, err)
+		}
+		for _, m := range matches {
+			if fi, err := os.Stat(m); err != nil || !fi.Mode().IsRegular() {
+				continue
+			}
+			rel, err := filepath.Rel(dir, m)
+			if err != nil {
+				return nil, err
+			}
+			rel = filepath.ToSlash(rel)
+			if seen[rel] {
+				continue
+			}
+			seen[rel] = true
+			dig, err := fileDigest(m)
+			if err != nil {
+				return nil, fmt.Errorf("unable to hash subject file %s: %w", rel, err)
+			}
+			subjects = append(subjects, intoto.Subject{
+				Name: rel,
+				Digest: common.DigestSet{
+					"sha256": dig,
+				},
+			})
+		}
+	}
+	return subjects, nil
+}
+
+// fileDigest streams the file to compute its sha256 hex digest
+func fileDigest(file string) (string, error) {
+	f, err := os.Open(file)
+	if err != nil {
+		return "", err
+	}
+	defer f.Close()
+	h := sha256.New()
+	if _, err := io.Copy(h, f); err != nil {
+		return "", err
+	}
+	return hex.EncodeToString(h.Sum(nil)), nil
+}
@@ -0,0 +1,139 @@
+package drone
+
+import (
+	"time"
+
+	"github.com/drone-runners/drone-runner-docker/engine"
+	"github.com/drone/drone-go/drone"
+	"github.com/drone/runner-go/pipeline"
+	"github.com/drone/runner-go/pipeline/runtime"
+)
+
+// failure modes of a run
+const (
+	modeFailFast  = "fail-fast"
+	modeKeepGoing = "keep-going"
+)
+
+// reasons of the steps that never run
+const (
+	skipExcluded    = "excluded"
+	skipNotIncluded = "not included"
+	skipResumeAt    = "resume-at"
+	skipWhen        = "when condition"
+	skipNoClone     = "no-clone"
+	skipSince       = "unchanged paths"
+	skipSelector    = "label selector"
+	// skipRuntime is a step skipped while running the pipeline
+	// e.g. due to its run policy or a failed dependency
+	skipRuntime = "run policy"
+)
+
+// runSummary summarizes the result of a pipeline run
+type runSummary struct {
+	Pipeline   string        `json:"pipeline"`
+	Status     string        `json:"status"`
+	Mode       string        `json:"mode"`
+	Started    int64         `json:"started,omitempty"`
+	Stopped    int64         `json:"stopped,omitempty"`
+	DurationMs int64         `json:"durationMs"`
+	Steps      []stepSummary `json:"steps"`
+	Cancelled  []string      `json:"cancelled,omitempty"`
+	Runs       []stepRun     `json:"runs,omitempty"`
+}
+
+// stepRun tells whether a step ran or why it was skipped
+type stepRun struct {
+	Name   string `json:"name"`
+	Result string `json:"result"`
+}
+
+// stepSummary summarizes the result of a pipeline step
+type stepSummary struct {
+	Number     int    `json:"number"`
+	Name       string `json:"name"`
+	Status     string `json:"status"`
+	ExitCode   int    `json:"exitCode"`
+	Error      string `json:"error,omitempty"`
+	DurationMs int64  `json:"durationMs"`
+	Attempts   int    `json:"attempts,omitempty"`
+}
+
+// newRunSummary builds the run summary from the pipeline state and the
+// durations of the steps and of the pipeline recorded during the run.
+func newRunSummary(commy *execCommand, state *pipeline.State, rec *stateRecorder, runs []stepRun) *runSummary {
+	state.Lock()
+	defer state.Unlock()
+	summary := &runSummary{
+		Runs:       runs,
+		Pipeline:   state.Stage.Name,
+		Status:     state.Stage.Status,
+		Mode:       modeKeepGoing,
+		Started:    state.Stage.Started,
+		Stopped:    state.Stage.Stopped,
+		DurationMs: rec.Duration().Milliseconds(),
+	}
+	if commy.FailFast {
+		summary.Mode = modeFailFast
+	}
+	if commy.TimedOut {
+		summary.Status = statusTimedOut
+	}
+	for _, s := range state.Stage.Steps {
+		if s.Status == drone.StatusKilled {
+			summary.Cancelled = append(summary.Cancelled, s.Name)
+		}
+		summary.Steps = append(summary.Steps, stepSummary{
+			Number:     s.Number,
+			Name:       s.Name,
+			Status:     s.Status,
+			ExitCode:   s.ExitCode,
+			Error:      s.Error,
+			DurationMs: stepDuration(rec, s.Name).Milliseconds(),
+			Attempts:   commy.StepAttempts[s.Name],
+		})
+	}
+	return summary
+}
+
+// skipStep never runs the step, recording the first reason it was skipped for
+func skipStep(skipped map[string]string, step *engine.Step, reason string) {
+	if _, ok := skipped[step.Name]; !ok {
+		skipped[step.Name] = reason
+	}
+	step.RunPolicy = runtime.RunNever
+}
+
+// stepRuns returns whether each step of the pipeline ran or why it was skipped,
+// from the run policy of the step and its recorded status.
+func stepRuns(spec *engine.Spec, rec *stateRecorder, skipped map[string]string) []stepRun {
+	status := map[string]string{}
+	for _, s := range rec.Steps() {
+		status[s.Name] = s.Status
+	}
+	runs := make([]stepRun, 0, len(spec.Steps))
+	for _, step := range spec.Steps {
+		reason, ok := skipped[step.Name]
+		if !ok && step.RunPolicy == runtime.RunNever {
+			reason, ok = skipWhen, true
+		}
+		if !ok && status[step.Name] == drone.StatusSkipped {
+			reason, ok = skipRuntime, true
+		}
+		result := "ran"
+		if ok {
+			result = "skipped (" + reason + ")"
+		}
+		runs = append(runs, stepRun{Name: step.Name, Result: result})
+	}
+	return runs
+}
+
+// stepDuration returns the recorded duration of the step, zero if it did not run
+func stepDuration(rec *stateRecorder, name string) time.Duration {
+	s, ok := rec.Step(name)
+	if !ok {
+		return 0
+	}
+	return s.Duration()
+}
@@ -0,0 +1,24 @@
+package drone
+
+import (
+	"fmt"
+	"io"
+	"text/tabwriter"
+	"time"
+)
+
+// writeTimings writes the step durations of the run summary as a table
+func writeTimings(w io.Writer, summary *runSummary) error {
+	tw := tabwriter.NewWriter(w, 0, 4, 2, ' ', 0)
+	fmt.Fprintln(tw, "STEP\tSTATUS\tDURATION")
+	for _, s := range summary.Steps {
+		fmt.Fprintf(tw, "%s\t%s\t%s\n", s.Name, s.Status, millis(s.DurationMs))
+	}
+	fmt.Fprintf(tw, "%s\t%s\t%s\n", "TOTAL", summary.Status, millis(summary.DurationMs))
+	return tw.Flush()
+}
+
+// millis formats the milliseconds as a duration
+func millis(ms int64) time.Duration {
+	return (time.Duration(ms) * time.Millisecond).Round(time.Millisecond)
+}
@@ -0,0 +1,135 @@
+package drone
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"os"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/drone-runners/drone-runner-docker/engine"
+	v1 "github.com/google/go-containerregistry/pkg/v1"
+	"github.com/urfave/cli/v2"
+)
+
+// image material verification results
+const (
+	verifyCurrent    = "current"
+	verifyDrifted    = "drifted"
+	verifyUnresolved = "unresolved"
+)
+
+// imageVerification is the verification result of an image material
+type imageVerification struct {
+	Image    string `json:"image"`
+	Status   string `json:"status"`
+	Recorded string `json:"recorded"`
+	Current  string `json:"current,omitempty"`
+	Error    string `json:"error,omitempty"`
+}
+
+// VerifyImageCommand exports the verify-image command.
+var VerifyImageCommand = &cli.Command{
+	Name:  "verify-image",
+	Usage: "check if the image materials of a provenance still resolve to the recorded digests",
+	Flags: []cli.Flag{
+		&cli.StringFlag{
+			Name:     "provenance",
+			Usage:    "provenance file to verify",
+			Required: true,
+		},
+		&cli.StringFlag{
+			Name:  "platform",
+			Usage: "platform of the images e.g. linux/arm64, defaults to linux and the architecture of this binary",
+		},
+		&cli.BoolFlag{
+			Name:  "fail-on-drift",
+			Usage: "exit with non-zero status when an image has drifted",
+		},
+		&cli.DurationFlag{
+			Name:  "timeout",
+			Usage: "timeout to resolve the image digests",
+			Value: 2 * time.Minute,
+		},
+		outputFlag(),
+	},
+	Action: verifyImage,
+}
+
+func verifyImage(cliContext *cli.Context) error {
+	output := cliContext.String("output")
+	if err := validateOutput(output); err != nil {
+		return err
+	}
+	platform := specPlatform(&engine.Spec{})
+	if p := cliContext.String("platform"); p != "" {
+		var err error
+		if platform, err = v1.ParsePlatform(p); err != nil {
+			return fmt.Errorf("invalid platform '%s' : %w", p, err)
+		}
+	}
+	prev, err := readPreviousMaterials(cliContext.String("provenance"))
+	if err != nil {
+		return err
+	}
+
+	ctx, cancel := context.WithTimeout(nocontext, cliContext.Duration("timeout"))
+	defer cancel()
+
+	keys := make([]string, 0, len(prev))
+	for k := range prev {
+		if strings.HasPrefix(k, "pkg:") {
+			keys = append(keys, k)
+		}
+	}
+	sort.Strings(keys)
+
+	results := []imageVerification{}
+	drifted := 0
+	for _, k := range keys {
+		v := imageVerification{
+			Image:    strings.TrimPrefix(k, "pkg:"),
+			Recorded: prev[k]["sha256"],
+		}
+		ds, err := imageDigests(ctx, v.Image, platform)
+		switch {
+		case err != nil:
+			v.Status = verifyUnresolved
+			v.Error = err.Error()
+		case ds["sha256"] != v.Recorded:
+			v.Status = verifyDrifted
+			v.Current = ds["sha256"]
+			drifted++
+		default:
+			v.Status = verifyCurrent
+			v.Current = ds["sha256"]
+		}
+		results = append(results, v)
+	}
+
+	if output == outputJSON {
+		enc := json.NewEncoder(os.Stdout)
+		enc.SetIndent("", "  ")
+		if err := enc.Encode(results); err != nil {
+			return err
+		}
+	} else {
+		for _, v := range results {
+			switch v.Status {
+			case verifyDrifted:
+				fmt.Printf("[%s] %s sha256:%s -> sha256:%s\n", v.Status, v.Image, v.Recorded, v.Current)
+			case verifyUnresolved:
+				fmt.Printf("[%s] %s: %s\n", v.Status, v.Image, v.Error)
+			default:
+				fmt.Printf("[%s] %s sha256:%s\n", v.Status, v.Image, v.Current)
+			}
+		}
+	}
+
+	if drifted > 0 && cliContext.Bool("fail-on-drift") {
+		return fmt.Errorf("%d of %d image(s) drifted", drifted, len(results))
+	}
+	return nil
+}
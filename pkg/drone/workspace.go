@@ -0,0 +1,49 @@
+package drone
+
+import (
+	"github.com/drone-runners/drone-runner-docker/engine"
+)
+
+const (
+	// workspaceVolumeName is the name the compiler gives to the workspace volume
+	workspaceVolumeName = "_workspace"
+	// outputVolumeName is the name of the writable volume used with a read-only source
+	outputVolumeName = "_output"
+	// outputVolumePath is the path where the writable output volume is mounted
+	outputVolumePath = "/drone/output"
+)
+
+// readonlySource mounts the host source directory as read-only and adds a writable
+// output volume to every step, the path of the output volume is available to the
+// steps via the DRONE_OUTPUT_DIR environment variable.
+func readonlySource(spec *engine.Spec) {
+	var ws *engine.VolumeHostPath
+	for _, v := range spec.Volumes {
+		if v.HostPath != nil && v.HostPath.Name == workspaceVolumeName {
+			ws = v.HostPath
+			break
+		}
+	}
+	if ws == nil {
+		return
+	}
+	ws.ReadOnly = true
+	spec.Volumes = append(spec.Volumes, &engine.Volume{
+		EmptyDir: &engine.VolumeEmptyDir{
+			ID:     ws.ID + "-output",
+			Name:   outputVolumeName,
+			Labels: ws.Labels,
+		},
+	})
+	for _, step := range spec.Steps {
+		step.Volumes = append(step.Volumes, &engine.VolumeMount{
+			Name: outputVolumeName,
+			Path: outputVolumePath,
+		})
+		if step.Envs == nil {
+			step.Envs = map[string]string{}
+		}
+		step.Envs["DRONE_OUTPUT_DIR"] = outputVolumePath
+	}
+	log.Infof("Source %s is mounted read-only, steps can write to %s", ws.Path, outputVolumePath)
+}
@@ -2,35 +2,45 @@ package utils
 
 import (
 	"context"
+	"fmt"
 	"io"
 	"os"
 	"runtime"
+	"time"
 
 	"github.com/docker/docker/api/types"
 	"github.com/docker/docker/api/types/container"
 	"github.com/docker/docker/client"
+	"github.com/sirupsen/logrus"
 )
 
 const (
-	busyboxImage = "docker.io/library/busybox"
+	// BusyboxImage is the default image used to trigger the extension UI refresh
+	BusyboxImage = "docker.io/library/busybox"
+	// UIRefreshImageEnv is the environment variable to override the UI refresh image e.g. with a private mirror
+	UIRefreshImageEnv = "DRONE_UI_REFRESH_IMAGE"
+	// UIRefreshTimeout bounds the creation, the start and the removal of the UI refresh container
+	UIRefreshTimeout = 10 * time.Second
 )
 
+// UIRefreshImage returns the image used to trigger the extension UI refresh
+func UIRefreshImage() string {
+	return LookupEnvOrString(UIRefreshImageEnv, BusyboxImage)
+}
+
 // TriggerUIRefresh starts a container to notify the extension UI to reload the progress actions from the cache.
 // The container uses the label "io.drone.desktop.ui.refresh=true" for that purpose and is auto-removed when exited.
 // The extension UI is listening for container events with that label. Once an event is received, the extension UI sends a ui refresh action to refresh and reload the pipelines from backend
-func TriggerUIRefresh(ctx context.Context, cli *client.Client, labels map[string]string) error {
+// The image defaults to UIRefreshImage and is pulled using the registryAuth, writing the pull progress to out, the refresh is skipped with a warning, logged with log, when the image can't be obtained.
+// Creating, starting and waiting for the container to be removed is bounded by UIRefreshTimeout, the container is removed when it fails to start.
+func TriggerUIRefresh(ctx context.Context, log logrus.FieldLogger, cli *client.Client, image, registryAuth string, labels map[string]string, out io.Writer) error {
+	if image == "" {
+		image = UIRefreshImage()
+	}
 	// Ensure the image is present before creating the container
-	if _, _, err := cli.ImageInspectWithRaw(ctx, busyboxImage); err != nil {
-		reader, err := cli.ImagePull(ctx, busyboxImage, types.ImagePullOptions{
-			Platform: "linux/" + runtime.GOARCH,
-		})
-		if err != nil {
-			return err
-		}
-		_, err = io.Copy(os.Stdout, reader)
-		if err != nil {
-			return err
-		}
+	if err := EnsureImageWithAuth(ctx, cli, image, registryAuth, out); err != nil {
+		log.Warnf("Skipping the UI refresh, unable to obtain the image %s: %v", image, err)
+		return nil
 	}
 
 	cLabels := map[string]string{
@@ -44,8 +54,11 @@ func TriggerUIRefresh(ctx context.Context, cli *client.Client, labels map[string
 		cLabels[k] = v
 	}
 
+	ctx, cancel := context.WithTimeout(ctx, UIRefreshTimeout)
+	defer cancel()
+
 	resp, err := cli.ContainerCreate(ctx, &container.Config{
-		Image:        busyboxImage,
+		Image:        image,
 		AttachStdout: true,
 		AttachStderr: true,
 		Labels:       cLabels,
@@ -53,13 +66,56 @@ func TriggerUIRefresh(ctx context.Context, cli *client.Client, labels map[string
 		AutoRemove: true,
 	}, nil, "")
 	if err != nil {
-		return err
+		return fmt.Errorf("unable to deliver the UI refresh, creating the container failed: %w", err)
+	}
+
+	// wait for the container to be auto-removed i.e. it ran and its events were emitted
+	waitC, errC := cli.ContainerWait(ctx, resp.ID, container.WaitConditionRemoved)
+
+	if err := cli.ContainerStart(ctx, resp.ID, types.ContainerStartOptions{}); err != nil {
+		// the container is not auto-removed when it does not start
+		removeContainer(log, cli, resp.ID)
+		return fmt.Errorf("unable to deliver the UI refresh, starting the container failed: %w", err)
+	}
+
+	select {
+	case <-waitC:
+		return nil
+	case err := <-errC:
+		removeContainer(log, cli, resp.ID)
+		return fmt.Errorf("unable to deliver the UI refresh, waiting for the container failed: %w", err)
 	}
+}
+
+// removeContainer force removes the container, with its own timeout as the
+// context of the caller might have been cancelled.
+func removeContainer(log logrus.FieldLogger, cli *client.Client, id string) {
+	ctx, cancel := context.WithTimeout(context.Background(), UIRefreshTimeout)
+	defer cancel()
+	if err := cli.ContainerRemove(ctx, id, types.ContainerRemoveOptions{Force: true}); err != nil && !client.IsErrNotFound(err) {
+		log.Warnf("Unable to remove the UI refresh container %s: %v", id, err)
+	}
+}
 
-	err = cli.ContainerStart(ctx, resp.ID, types.ContainerStartOptions{})
+// EnsureImage pulls the image for the current architecture if it is not present on the host.
+func EnsureImage(ctx context.Context, cli *client.Client, image string) error {
+	return EnsureImageWithAuth(ctx, cli, image, "", os.Stdout)
+}
+
+// EnsureImageWithAuth is EnsureImage authenticating the pull with the encoded registry credentials,
+// the pull progress is written to out.
+func EnsureImageWithAuth(ctx context.Context, cli *client.Client, image, registryAuth string, out io.Writer) error {
+	if _, _, err := cli.ImageInspectWithRaw(ctx, image); err == nil {
+		return nil
+	}
+	reader, err := cli.ImagePull(ctx, image, types.ImagePullOptions{
+		Platform:     "linux/" + runtime.GOARCH,
+		RegistryAuth: registryAuth,
+	})
 	if err != nil {
 		return err
 	}
-
-	return nil
+	defer reader.Close()
+	_, err = io.Copy(out, reader)
+	return err
 }
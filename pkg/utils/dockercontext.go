@@ -0,0 +1,76 @@
+package utils
+
+import (
+	"crypto/sha256"
+	"encoding/json"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"github.com/docker/cli/cli/config"
+)
+
+// DefaultDockerContext is the docker context of the docker environment variables
+const DefaultDockerContext = "default"
+
+// dockerContextMeta is the metadata of a docker context as stored by the docker cli
+type dockerContextMeta struct {
+	Name      string `json:"Name"`
+	Endpoints map[string]struct {
+		Host          string `json:"Host"`
+		SkipTLSVerify bool   `json:"SkipTLSVerify"`
+	} `json:"Endpoints"`
+}
+
+// UseDockerContext points the docker clients, which are configured from the
+// environment, to the docker endpoint and TLS material of the named docker
+// context from the docker config directory.
+func UseDockerContext(name string) error {
+	if name == "" || name == DefaultDockerContext {
+		return nil
+	}
+	// the docker cli stores the contexts in directories named after the digest of their name
+	id := fmt.Sprintf("%x", sha256.Sum256([]byte(name)))
+	metaFile := filepath.Join(config.Dir(), "contexts", "meta", id, "meta.json")
+	b, err := os.ReadFile(metaFile)
+	if os.IsNotExist(err) {
+		return fmt.Errorf("docker context '%s' not found in %s", name, config.Dir())
+	}
+	if err != nil {
+		return fmt.Errorf("unable to read docker context '%s': %w", name, err)
+	}
+	var meta dockerContextMeta
+	if err := json.Unmarshal(b, &meta); err != nil {
+		return fmt.Errorf("unable to parse docker context '%s' %s: %w", name, metaFile, err)
+	}
+	ep, ok := meta.Endpoints["docker"]
+	if !ok || ep.Host == "" {
+		return fmt.Errorf("docker context '%s' has no docker endpoint", name)
+	}
+	if strings.HasPrefix(ep.Host, "ssh://") {
+		return fmt.Errorf("docker context '%s' uses the ssh endpoint %s, which is not supported", name, ep.Host)
+	}
+	env := map[string]string{
+		"DOCKER_HOST":       ep.Host,
+		"DOCKER_CERT_PATH":  "",
+		"DOCKER_TLS_VERIFY": "",
+	}
+	tlsDir := filepath.Join(config.Dir(), "contexts", "tls", id, "docker")
+	if _, err := os.Stat(tlsDir); err == nil {
+		env["DOCKER_CERT_PATH"] = tlsDir
+		if !ep.SkipTLSVerify {
+			env["DOCKER_TLS_VERIFY"] = "1"
+		}
+	}
+	for k, v := range env {
+		if v == "" {
+			os.Unsetenv(k)
+			continue
+		}
+		if err := os.Setenv(k, v); err != nil {
+			return err
+		}
+	}
+	return nil
+}
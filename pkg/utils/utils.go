@@ -1,7 +1,8 @@
 package utils
 
 import (
-	"crypto/md5"
+	"context"
+	"crypto/sha256"
 	"fmt"
 	"io"
 	"os"
@@ -15,7 +16,7 @@ func LogSetup(out io.Writer, level string) *logrus.Logger {
 	lvl, err := logrus.ParseLevel(level)
 
 	if err != nil {
-		logrus.Warnf("Unable to use the %s level, %#v. Defaulting to warning.")
+		logrus.Warnf("Unable to use the %s level, %#v. Defaulting to warning.", level, err)
 		lvl = logrus.WarnLevel
 	}
 
@@ -25,6 +26,7 @@ func LogSetup(out io.Writer, level string) *logrus.Logger {
 			TimestampFormat: "2006-01-02 15:15:10",
 		},
 		Out:          out,
+		Hooks:        make(logrus.LevelHooks),
 		ReportCaller: false,
 		Level:        lvl,
 	}
@@ -32,6 +34,78 @@ func LogSetup(out io.Writer, level string) *logrus.Logger {
 	return log
 }
 
+// NoColorEnv is the environment variable that, when set to any value,
+// disables the colored output, see https://no-color.org
+const NoColorEnv = "NO_COLOR"
+
+// NoColor returns true when the colored output is disabled via NO_COLOR
+func NoColor() bool {
+	return os.Getenv(NoColorEnv) != ""
+}
+
+// IsTerminal returns true when the file is a terminal e.g. os.Stdout not being redirected
+func IsTerminal(f *os.File) bool {
+	fi, err := f.Stat()
+	return err == nil && fi.Mode()&os.ModeCharDevice != 0
+}
+
+// DisableColors disables the colors of the log entries. The text formatter
+// already disables them when the output of the logger is not a terminal.
+func DisableColors(log *logrus.Logger) {
+	if f, ok := log.Formatter.(*logrus.TextFormatter); ok {
+		f.DisableColors = true
+	}
+}
+
+// TraceFile writes all the log entries, down to the trace level, to the file while
+// the entries written to the current output of the logger are still filtered by its
+// level. The returned closer closes the trace file.
+func TraceFile(log *logrus.Logger, file string) (io.Closer, error) {
+	f, err := os.Create(file)
+	if err != nil {
+		return nil, err
+	}
+	log.AddHook(&writerHook{
+		out:       log.Out,
+		formatter: log.Formatter,
+		levels:    logrus.AllLevels[:log.Level+1],
+	})
+	log.AddHook(&writerHook{
+		out: f,
+		formatter: &logrus.TextFormatter{
+			FullTimestamp:   true,
+			TimestampFormat: "2006-01-02 15:15:10",
+			DisableColors:   true,
+		},
+		levels: logrus.AllLevels,
+	})
+	log.SetOutput(io.Discard)
+	log.SetLevel(logrus.TraceLevel)
+	return f, nil
+}
+
+// writerHook is a logrus hook that writes the entries of its levels to the output
+type writerHook struct {
+	out       io.Writer
+	formatter logrus.Formatter
+	levels    []logrus.Level
+}
+
+// Levels implements logrus.Hook
+func (h *writerHook) Levels() []logrus.Level {
+	return h.levels
+}
+
+// Fire implements logrus.Hook
+func (h *writerHook) Fire(entry *logrus.Entry) error {
+	b, err := h.formatter.Format(entry)
+	if err != nil {
+		return err
+	}
+	_, err = h.out.Write(b)
+	return err
+}
+
 // LookupEnvOrString looks up an environment variable if not found
 // returns defaultVal
 func LookupEnvOrString(envName, defaultVal string) string {
@@ -42,11 +116,18 @@ func LookupEnvOrString(envName, defaultVal string) string {
 	return defaultVal
 }
 
-// Md5OfString returns the md5 has of the string
-// Its ok to use md5 hashing here as it just used
-// for consistent and sanitized naming
+// HashOfString returns the sha256 hash of the string truncated to 32 hex
+// characters. It is used for consistent and sanitized naming and unlike md5
+// it is available when running in FIPS mode.
+func HashOfString(str string) string {
+	return fmt.Sprintf("%x", sha256.Sum256([]byte(str)))[:32]
+}
+
+// Md5OfString returns the hash of the string used for naming.
+//
+// Deprecated: use HashOfString, the hash is no longer md5.
 func Md5OfString(str string) string {
-	return fmt.Sprintf("%x", md5.Sum([]byte(str)))
+	return HashOfString(str)
 }
 
 // DockerCliClient builds a Docker Cli Client to interact Docker
@@ -57,3 +138,13 @@ func DockerCliClient() (*client.Client, error) {
 	}
 	return cli, nil
 }
+
+// PingDocker checks if the Docker daemon is reachable, returning an error
+// with the daemon host and how to start Docker when it is not.
+func PingDocker(ctx context.Context, cli *client.Client) error {
+	if _, err := cli.Ping(ctx); err != nil {
+		return fmt.Errorf("docker is not reachable at %s, start Docker (Docker Desktop or 'sudo systemctl start docker') "+
+			"or set DOCKER_HOST to a running daemon: %w", cli.DaemonHost(), err)
+	}
+	return nil
+}